@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 访问令牌的权限范围
+const (
+	scopeRead       = "read"       // 读取导航、页面和历史版本
+	scopeLinks      = "links"      // 新增、修改、删除、排序链接，获取图标，恢复历史版本
+	scopeCategories = "categories" // 调整分类，管理页面
+	scopeAdmin      = "admin"      // 管理用户
+)
+
+var validScopes = map[string]bool{
+	scopeRead:       true,
+	scopeLinks:      true,
+	scopeCategories: true,
+	scopeAdmin:      true,
+}
+
+const (
+	apiTokenPrefix      = "tnp_"    // 访问令牌的前缀，用来和登录令牌区分
+	apiTokenTouchPeriod = time.Hour // lastUsedAt 的保存间隔，避免每次请求都写存储
+)
+
+// APIToken 给脚本和自动化使用的长期访问令牌。
+// 与登录令牌分开保存，不受 defaulttokenCount 的数量限制；只保存令牌的 SHA-256。
+type APIToken struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Username   string   `json:"username"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"createdAt"`
+	ExpiresAt  int64    `json:"expiresAt,omitempty"` // Unix 秒，0 表示不过期
+	LastUsedAt int64    `json:"lastUsedAt,omitempty"`
+	Hash       string   `json:"hash,omitempty"`
+}
+
+func (t APIToken) expired(now time.Time) bool {
+	return t.ExpiresAt != 0 && now.Unix() >= t.ExpiresAt
+}
+
+var apiTokenStore *APITokenStore
+
+// APITokenStore 管理访问令牌
+type APITokenStore struct {
+	mu      sync.Mutex
+	tokens  map[string]APIToken // 按 id
+	storage Storage
+}
+
+func NewAPITokenStore(storage Storage) *APITokenStore {
+	s := &APITokenStore{
+		tokens:  make(map[string]APIToken),
+		storage: storage,
+	}
+
+	data, err := storage.Load(storageAPITokens)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading api tokens: %v", err)
+		}
+		return s
+	}
+	if err := json.Unmarshal(data, &s.tokens); err != nil {
+		log.Printf("Error unmarshaling api tokens: %v", err)
+		s.tokens = make(map[string]APIToken)
+	}
+	return s
+}
+
+func (s *APITokenStore) save() error {
+	data, err := json.MarshalIndent(s.tokens, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling api tokens: %v", err)
+	}
+	return s.storage.Save(storageAPITokens, data)
+}
+
+// Create 创建访问令牌，返回令牌信息和令牌本身（只在创建时返回一次）
+func (s *APITokenStore) Create(username string, name string, scopes []string, expiresAt int64) (APIToken, string, error) {
+	raw, err := generateToken()
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	// 去掉 base64 中的 +/=，方便在 URL 和 shell 中使用
+	value := apiTokenPrefix + strings.NewReplacer("+", "", "/", "", "=", "").Replace(raw)
+
+	token := APIToken{
+		ID:        generateID(),
+		Name:      name,
+		Username:  username,
+		Scopes:    scopes,
+		CreatedAt: time.Now().Unix(),
+		ExpiresAt: expiresAt,
+		Hash:      hashToken(value),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.ID] = token
+	if err := s.save(); err != nil {
+		delete(s.tokens, token.ID)
+		return APIToken{}, "", err
+	}
+	token.Hash = ""
+	return token, value, nil
+}
+
+// Validate 校验访问令牌，有效时返回令牌信息
+func (s *APITokenStore) Validate(value string) (APIToken, bool) {
+	hash := hashToken(value)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.tokens {
+		if !constantTimeEqual(token.Hash, hash) {
+			continue
+		}
+		if token.expired(now) {
+			return APIToken{}, false
+		}
+		if now.Unix()-token.LastUsedAt >= int64(apiTokenTouchPeriod/time.Second) {
+			token.LastUsedAt = now.Unix()
+			s.tokens[id] = token
+			if err := s.save(); err != nil {
+				log.Printf("Error saving api tokens: %v", err)
+			}
+		}
+		return token, true
+	}
+	return APIToken{}, false
+}
+
+// List 返回 username 的令牌（不含哈希），all 为 true 时返回所有用户的令牌
+func (s *APITokenStore) List(username string, all bool) []APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]APIToken, 0)
+	for _, token := range s.tokens {
+		if !all && token.Username != username {
+			continue
+		}
+		token.Hash = ""
+		list = append(list, token)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt < list[j].CreatedAt
+	})
+	return list
+}
+
+// Revoke 删除令牌。all 为 false 时只能删除 username 自己的令牌
+func (s *APITokenStore) Revoke(id string, username string, all bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok || (!all && token.Username != username) {
+		return newRequestError(http.StatusNotFound, "Token not found")
+	}
+	delete(s.tokens, id)
+	return s.save()
+}
+
+// RevokeUser 删除某个用户的所有访问令牌
+func (s *APITokenStore) RevokeUser(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.tokens {
+		if token.Username == username {
+			delete(s.tokens, id)
+		}
+	}
+	if err := s.save(); err != nil {
+		log.Printf("Error saving api tokens: %v", err)
+	}
+}
+
+// requestScope 返回请求需要的权限范围
+func requestScope(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/admin/"):
+		return scopeAdmin
+	case r.URL.Path == "/get-icon":
+		// 获取图标只在编辑链接时使用
+		return scopeLinks
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return scopeRead
+	case r.URL.Path == "/navigation/categories", r.URL.Path == "/pages", strings.HasPrefix(r.URL.Path, "/pages/"):
+		return scopeCategories
+	default:
+		return scopeLinks
+	}
+}
+
+// hasScope 判断令牌是否有 scope 权限，登录令牌没有范围限制
+func (info AuthInfo) hasScope(scope string) bool {
+	if info.Scopes == nil {
+		return true
+	}
+	for _, s := range info.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APITokenRequest 创建访问令牌的请求体
+type APITokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt int64    `json:"expiresAt"` // Unix 秒，0 表示不过期
+}
+
+// APITokenResponse 创建访问令牌的响应，token 只返回这一次
+type APITokenResponse struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+// apiTokensHandler 访问令牌管理接口，只能用登录令牌调用:
+//
+//	GET    /tokens       列出自己的访问令牌，管理员列出所有用户的
+//	POST   /tokens       创建访问令牌
+//	DELETE /tokens/{id}  撤销访问令牌
+func apiTokensHandler(w http.ResponseWriter, r *http.Request) {
+	info, _ := requestAuth(r)
+	if info.Scopes != nil {
+		http.Error(w, "Access tokens cannot manage access tokens", http.StatusForbidden)
+		return
+	}
+	isAdmin := hasRole(info.Role, roleAdmin)
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/tokens"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		writeJSON(w, apiTokenStore.List(info.Username, isAdmin))
+	case id == "" && r.Method == http.MethodPost:
+		var req APITokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" {
+			http.Error(w, "Name required", http.StatusBadRequest)
+			return
+		}
+		if len(req.Scopes) == 0 {
+			http.Error(w, "At least one scope required", http.StatusBadRequest)
+			return
+		}
+		for _, scope := range req.Scopes {
+			if !validScopes[scope] {
+				http.Error(w, fmt.Sprintf("Invalid scope: %s", scope), http.StatusBadRequest)
+				return
+			}
+			if scope == scopeAdmin && !isAdmin {
+				http.Error(w, "Scope 'admin' requires the admin role", http.StatusForbidden)
+				return
+			}
+		}
+		if req.ExpiresAt != 0 && req.ExpiresAt <= time.Now().Unix() {
+			http.Error(w, "expiresAt must be in the future", http.StatusBadRequest)
+			return
+		}
+		token, value, err := apiTokenStore.Create(info.Username, req.Name, req.Scopes, req.ExpiresAt)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("API token created: id=%s name=%q user=%q scopes=%v", token.ID, token.Name, token.Username, token.Scopes)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(APITokenResponse{APIToken: token, Token: value})
+	case id != "" && r.Method == http.MethodDelete:
+		if err := apiTokenStore.Revoke(id, info.Username, isAdmin); err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("API token revoked: id=%s by user=%q", id, info.Username)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/ini.v1"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBackupKeep   = 7 // 默认保留的备份数量
+	backupFilePrefix    = "tiny-nav-backup-"
+	backupFileSuffix    = ".tar.gz"
+	backupTimeFormat    = "20060102-150405"
+	settingLastBackupAt = "backup.lastRunAt" // 上次备份时间（Unix 秒），重启后按此计算下次备份
+)
+
+// startBackupScheduler 按 BACKUP_INTERVAL 定期备份 data 目录，interval 为 0 时不启用
+func startBackupScheduler(interval time.Duration, dir string, keep int) {
+	if interval <= 0 {
+		return
+	}
+	log.Printf("Backup scheduler enabled: every %v into %s, keep %d", interval, dir, keep)
+
+	// 上次备份时间只在启动时从设置中读取，之后以内存中的值为准：
+	// 保存设置失败时也不会立即再次备份
+	var lastRun time.Time
+	if v := settingsStore.Get(settingLastBackupAt, ""); v != "" {
+		if last, err := strconv.ParseInt(v, 10, 64); err == nil {
+			lastRun = time.Unix(last, 0)
+		}
+	}
+
+	go func() {
+		for {
+			if !lastRun.IsZero() {
+				time.Sleep(time.Until(lastRun.Add(interval)))
+			}
+
+			path, err := createBackup(dir)
+			if err != nil {
+				log.Printf("Backup failed: %v", err)
+				// 失败后等一个周期再重试，避免频繁重试
+			} else {
+				log.Printf("Backup created: %s", path)
+				if err := pruneBackups(dir, keep); err != nil {
+					log.Printf("Failed to prune old backups: %v", err)
+				}
+			}
+			// 无论成功与否都从本次结束时开始计时，两次备份之间至少间隔 interval
+			lastRun = time.Now()
+			if err := settingsStore.Set(settingLastBackupAt, strconv.FormatInt(lastRun.Unix(), 10)); err != nil {
+				log.Printf("Failed to save backup time: %v", err)
+			}
+		}
+	}()
+}
+
+// createBackup 把 data 目录打包为 dir 下带时间戳的 tar.gz 文件，返回备份文件路径
+func createBackup(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %v", err)
+	}
+	absBackupDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	name := backupFilePrefix + time.Now().Format(backupTimeFormat) + backupFileSuffix
+	path := filepath.Join(dir, name)
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	gw := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(gw)
+
+	err = filepath.Walk(dataDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// 不备份备份目录自身
+		if abs, err := filepath.Abs(file); err == nil && abs == absBackupDir {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || skipBackupFile(info.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dataDir, file)
+		if err != nil {
+			return err
+		}
+
+		// SQLite 正在使用中，直接复制文件可能不一致，用 VACUUM INTO 导出一致的副本
+		if info.Name() == sqliteFileName {
+			if s, ok := dataStorage.(*sqliteStorage); ok {
+				snapshot, err := s.snapshot(dir)
+				if err != nil {
+					return err
+				}
+				defer os.Remove(snapshot)
+				return addFileToTar(tw, snapshot, filepath.ToSlash(rel))
+			}
+		}
+		return addFileToTar(tw, file, filepath.ToSlash(rel))
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gw.Close(); err != nil {
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// skipBackupFile 跳过临时文件和 SQLite 的 WAL 文件（已包含在 VACUUM INTO 导出的副本中）
+func skipBackupFile(name string) bool {
+	return strings.Contains(name, ".tmp-") ||
+		strings.HasSuffix(name, ".db-wal") ||
+		strings.HasSuffix(name, ".db-shm")
+}
+
+func addFileToTar(tw *tar.Writer, file string, name string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// snapshot 用 VACUUM INTO 导出数据库的一致副本到 dir，返回副本路径
+func (s *sqliteStorage) snapshot(dir string) (string, error) {
+	path := filepath.Join(dir, fmt.Sprintf(".%s.tmp-%d", sqliteFileName, time.Now().UnixNano()))
+	if _, err := s.db.Exec(`VACUUM INTO ?`, path); err != nil {
+		return "", fmt.Errorf("failed to snapshot sqlite database: %v", err)
+	}
+	return path, nil
+}
+
+// listBackups 返回 dir 下的备份文件名，按时间从旧到新排列
+func listBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, backupFilePrefix) && strings.HasSuffix(name, backupFileSuffix) {
+			names = append(names, name)
+		}
+	}
+	// 文件名中的时间戳格式保证按字符串排序即为按时间排序
+	sort.Strings(names)
+	return names, nil
+}
+
+// pruneBackups 只保留最新的 keep 个备份
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	names, err := listBackups(dir)
+	if err != nil {
+		return err
+	}
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		log.Printf("Removed old backup: %s", names[0])
+		names = names[1:]
+	}
+	return nil
+}
+
+// runRestoreCommand 实现 `tiny-nav restore <backup.tar.gz>`：
+// 校验备份后替换 data 目录，原目录重命名保留。需要先停止服务。
+func runRestoreCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s restore <backup%s>\n", os.Args[0], backupFileSuffix)
+		os.Exit(2)
+	}
+	snapshot := args[0]
+
+	// 解压到 data 目录旁边的临时目录，保证之后可以直接 rename
+	parent := filepath.Dir(filepath.Clean(dataDir))
+	tmpDir, err := os.MkdirTemp(parent, "."+filepath.Base(dataDir)+".restore-*")
+	if err != nil {
+		log.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := extractBackup(snapshot, tmpDir); err != nil {
+		log.Fatalf("Invalid backup %s: %v", snapshot, err)
+	}
+	if err := validateBackup(tmpDir); err != nil {
+		log.Fatalf("Invalid backup %s: %v", snapshot, err)
+	}
+
+	previous := ""
+	if _, err := os.Stat(dataDir); err == nil {
+		previous = fmt.Sprintf("%s.before-restore-%s", filepath.Clean(dataDir), time.Now().Format(backupTimeFormat))
+		if err := os.Rename(dataDir, previous); err != nil {
+			log.Fatalf("Failed to move current data directory: %v", err)
+		}
+	}
+	if err := os.Rename(tmpDir, dataDir); err != nil {
+		if previous != "" {
+			os.Rename(previous, dataDir)
+		}
+		log.Fatalf("Failed to restore data directory: %v", err)
+	}
+
+	// 备份目录在 data 目录内时，把已有的备份搬回来
+	if previous != "" {
+		if rel, err := filepath.Rel(dataDir, envBackupDir); err == nil && !strings.HasPrefix(rel, "..") && rel != "." {
+			oldBackupDir := filepath.Join(previous, rel)
+			if _, err := os.Stat(oldBackupDir); err == nil {
+				os.MkdirAll(filepath.Dir(envBackupDir), 0755)
+				if err := os.Rename(oldBackupDir, envBackupDir); err != nil {
+					log.Printf("Failed to move backups into restored data directory: %v", err)
+				}
+			}
+		}
+		log.Printf("Previous data directory kept at %s", previous)
+	}
+	log.Printf("Restored %s into %s", snapshot, dataDir)
+}
+
+// extractBackup 解压备份文件，拒绝绝对路径、.. 和非普通文件
+func extractBackup(snapshot string, dst string) error {
+	f, err := os.Open(snapshot)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("unexpected entry type in backup: %s", header.Name)
+		}
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("unsafe path in backup: %s", header.Name)
+		}
+
+		target := filepath.Join(dst, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+}
+
+// validateBackup 检查解压后的数据是否可用
+func validateBackup(dir string) error {
+	navPath := filepath.Join(dir, storageNavigation+".json")
+	dbPath := filepath.Join(dir, sqliteFileName)
+	_, navErr := os.Stat(navPath)
+	_, dbErr := os.Stat(dbPath)
+	if navErr != nil && dbErr != nil {
+		return errors.New("backup contains no navigation data")
+	}
+
+	// JSON 文件必须能正常解析
+	documents := map[string]interface{}{
+		storageNavigation: &Navigation{},
+		storageTokens:     &map[string]Token{},
+		storageSettings:   &map[string]string{},
+		storageRevisions:  &[]Revision{},
+		storageUsers:      &map[string]Account{},
+		storagePages:      &[]Page{},
+		storageAPITokens:  &map[string]APIToken{},
+		storageTOTP:       &map[string]TOTPState{},
+	}
+	for name, v := range documents {
+		data, err := os.ReadFile(filepath.Join(dir, name+".json"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("%s.json is corrupted: %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, configFileName)); err == nil {
+		if _, err := ini.Load(filepath.Join(dir, configFileName)); err != nil {
+			return fmt.Errorf("%s is corrupted: %v", configFileName, err)
+		}
+	}
+
+	if dbErr == nil {
+		s, err := newSQLiteStorage(dbPath)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		var result string
+		if err := s.db.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+			return fmt.Errorf("%s is corrupted: %v", sqliteFileName, err)
+		}
+		if result != "ok" {
+			return fmt.Errorf("%s is corrupted: %s", sqliteFileName, result)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// 每个页面有两种导航板：所有人共用的 shared，以及每个用户自己的 personal。
+// /navigation 返回两者合并后的视图，链接的 board 字段标明它属于哪个导航板。
+const (
+	boardShared   = "shared"
+	boardPersonal = "personal"
+)
+
+// navigationView 一个请求能看到的导航板
+type navigationView struct {
+	r        *http.Request
+	shared   *NavigationStore
+	personal *NavigationStore // 匿名浏览或无用户密码模式下没有个人导航板
+}
+
+// requestView 返回请求所属页面上的导航板
+func requestView(r *http.Request) navigationView {
+	page := pageStore.Open(requestPage(r))
+	view := navigationView{r: r, shared: page.nav}
+	if info, ok := requestAuth(r); ok && info.Username != "" {
+		view.personal = page.Personal(info.Username)
+	}
+	return view
+}
+
+// Load 返回合并后的导航数据
+func (v navigationView) Load() (Navigation, error) {
+	shared, err := v.shared.Load()
+	if err != nil {
+		return Navigation{}, err
+	}
+	var personal Navigation
+	if v.personal != nil {
+		if personal, err = v.personal.Load(); err != nil {
+			return Navigation{}, err
+		}
+	}
+	return mergeBoards(shared, personal), nil
+}
+
+// stores 返回 board 对应的导航板和视图中的另一个导航板（可能为 nil）
+func (v navigationView) stores(board string) (*NavigationStore, *NavigationStore, error) {
+	switch board {
+	case boardShared:
+		return v.shared, v.personal, nil
+	case boardPersonal:
+		if v.personal == nil {
+			return nil, nil, newRequestError(http.StatusBadRequest, "Personal board requires a user account")
+		}
+		return v.personal, v.shared, nil
+	default:
+		return nil, nil, newRequestError(http.StatusBadRequest, "Unknown board: %s", board)
+	}
+}
+
+// Update 修改 board 导航板。修改前按请求的 If-Match 或 baseLastModified 校验整个视图的版本，
+// 返回修改后合并的视图；出错时返回当前合并的视图和该错误。
+func (v navigationView) Update(board string, baseLastModified int64, change Change, fn func(nav *Navigation) error) (Navigation, error) {
+	return v.update(board, func(version int64) error {
+		return checkNavigationVersion(v.r, version, baseLastModified)
+	}, change, fn)
+}
+
+// update 同 Update，check 为 nil 时不校验版本
+func (v navigationView) update(board string, check func(version int64) error, change Change, fn func(nav *Navigation) error) (Navigation, error) {
+	target, other, err := v.stores(board)
+	if err != nil {
+		return Navigation{}, err
+	}
+	// 先读取另一个导航板，不能在 target 的锁内读取，否则两个导航板同时修改时会死锁
+	var otherNav Navigation
+	if other != nil {
+		if otherNav, err = other.Load(); err != nil {
+			return Navigation{}, err
+		}
+	}
+
+	nav, err := target.Update(change, func(nav *Navigation) error {
+		if check != nil {
+			version := nav.LastModified
+			if otherNav.LastModified > version {
+				version = otherNav.LastModified
+			}
+			if err := check(version); err != nil {
+				return err
+			}
+		}
+		return fn(nav)
+	})
+	if board == boardShared {
+		return mergeBoards(nav, otherNav), err
+	}
+	return mergeBoards(otherNav, nav), err
+}
+
+// UpdateEach 依次修改多个导航板（共享的在前），只在第一次修改前校验版本。
+// 保存前先在副本上执行所有 fn，任一返回错误时都不保存；后面的导航板保存失败时撤销前面的修改，
+// 出错时返回当前合并的视图和该错误。fns 为空时只校验版本，不做修改。
+func (v navigationView) UpdateEach(baseLastModified int64, change Change, fns map[string]func(nav *Navigation) error) (Navigation, error) {
+	check := func(version int64) error {
+		return checkNavigationVersion(v.r, version, baseLastModified)
+	}
+	current, err := v.Load()
+	if err != nil {
+		return current, err
+	}
+	if len(fns) == 0 {
+		return current, check(current.LastModified)
+	}
+
+	// 先在副本上执行，避免只修改了一个导航板
+	for _, board := range []string{boardShared, boardPersonal} {
+		fn, ok := fns[board]
+		if !ok {
+			continue
+		}
+		target, _, err := v.stores(board)
+		if err != nil {
+			return current, err
+		}
+		nav, err := target.Load()
+		if err == nil {
+			nav, err = copyNavigation(nav)
+		}
+		if err == nil {
+			err = fn(&nav)
+		}
+		if err != nil {
+			return current, err
+		}
+	}
+
+	var nav Navigation
+	var saved *NavigationStore // 已经保存的导航板
+	var before Navigation      // saved 修改前的数据
+	var savedVersion int64     // saved 修改后的版本
+	for _, board := range []string{boardShared, boardPersonal} {
+		fn, ok := fns[board]
+		if !ok {
+			continue
+		}
+		target, _, _ := v.stores(board)
+		var original Navigation
+		if nav, err = v.update(board, check, change, func(nav *Navigation) error {
+			var err error
+			if original, err = copyNavigation(*nav); err != nil {
+				return err
+			}
+			return fn(nav)
+		}); err != nil {
+			if saved != nil {
+				v.rollback(saved, before, savedVersion, change)
+				if merged, loadErr := v.Load(); loadErr == nil {
+					nav = merged
+				}
+			}
+			return nav, err
+		}
+		check = nil
+		if latest, err := target.Load(); err == nil {
+			saved, before, savedVersion = target, original, latest.LastModified
+		}
+	}
+	return nav, nil
+}
+
+// rollback 把 store 恢复为 before，用于撤销 UpdateEach 中已保存的修改。
+// 保存后又被其他请求修改过（版本不是 version）时不再恢复，避免覆盖别人的修改
+func (v navigationView) rollback(store *NavigationStore, before Navigation, version int64, change Change) {
+	change.Action = "rollback"
+	_, err := store.Update(change, func(nav *Navigation) error {
+		if nav.LastModified != version {
+			return newRequestError(http.StatusConflict, "navigation changed since the partial update")
+		}
+		nav.Links, nav.Categories = before.Links, before.Categories
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to roll back partial navigation update: %v", err)
+	}
+}
+
+// linkAt 返回合并视图中第 index 个链接，兼容按索引操作的旧接口
+func (v navigationView) linkAt(index int) (Link, error) {
+	nav, err := v.Load()
+	if err != nil {
+		return Link{}, err
+	}
+	if index < 0 || index >= len(nav.Links) {
+		return Link{}, newRequestError(http.StatusBadRequest, "Index out of range")
+	}
+	return nav.Links[index], nil
+}
+
+// linkBoard 返回链接 id 所在的导航板
+func (v navigationView) linkBoard(id string) (string, error) {
+	nav, err := v.Load()
+	if err != nil {
+		return "", err
+	}
+	index := findLinkIndex(&nav, id)
+	if index < 0 {
+		return "", newRequestError(http.StatusNotFound, "Link not found")
+	}
+	return nav.Links[index].Board, nil
+}
+
+// mergeBoards 合并共享和个人导航板：共享的链接和分类在前，个人的在后，
+// 版本号取两者的较大值
+func mergeBoards(shared Navigation, personal Navigation) Navigation {
+	merged := Navigation{
+		Links:        make([]Link, 0, len(shared.Links)+len(personal.Links)),
+		Categories:   make([]string, 0, len(shared.Categories)+len(personal.Categories)),
+		LastModified: shared.LastModified,
+	}
+	if personal.LastModified > merged.LastModified {
+		merged.LastModified = personal.LastModified
+	}
+
+	for _, link := range shared.Links {
+		link.Board = boardShared
+		merged.Links = append(merged.Links, link)
+	}
+	for _, link := range personal.Links {
+		link.Board = boardPersonal
+		merged.Links = append(merged.Links, link)
+	}
+
+	seen := make(map[string]bool)
+	for _, categories := range [][]string{shared.Categories, personal.Categories} {
+		for _, category := range categories {
+			if !seen[category] {
+				seen[category] = true
+				merged.Categories = append(merged.Categories, category)
+			}
+		}
+	}
+	return merged
+}
+
+// requestBoard 返回请求要修改的导航板：优先使用 ?board= 查询参数，其次是请求体中的 board 字段，
+// 都没有时为 fallback
+func requestBoard(r *http.Request, board string, fallback string) string {
+	if v := r.URL.Query().Get("board"); v != "" {
+		return v
+	}
+	if board != "" {
+		return board
+	}
+	return fallback
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	corsAllowMethods  = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowHeaders  = "Accept, Content-Type, Content-Length, Accept-Encoding, Authorization, If-Match, If-None-Match, X-CSRF-Token"
+	corsExposeHeaders = "Authorization, ETag, X-TOTP-Required, X-TOTP-Enrollment-Required"
+	corsMaxAge        = "86400" // 缓存预检请求结果
+)
+
+// CORS 配置，CORS_ALLOWED_ORIGINS 为空时不允许跨域访问
+var (
+	envCORSOrigins     []string // 允许的来源，支持 https://*.example.com 匹配子域名，* 匹配任意来源（不带凭证）
+	envCORSRoutes      []string // 允许跨域访问的路径前缀，为空表示所有路径
+	envCORSCredentials bool     // 是否允许携带 cookie
+)
+
+// splitList 解析逗号分隔的列表，忽略空项
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseCORSOrigins 解析允许的来源，去掉末尾的 / 并统一小写
+func parseCORSOrigins(s string) []string {
+	origins := splitList(s)
+	for i, origin := range origins {
+		origins[i] = strings.ToLower(strings.TrimSuffix(origin, "/"))
+	}
+	return origins
+}
+
+// corsOriginAllowed 判断来源是否在允许列表中。
+// *.example.com 只匹配子域名（不含 example.com 本身），协议必须一致
+func corsOriginAllowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range envCORSOrigins {
+		if allowed == origin {
+			return true
+		}
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if !ok {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(origin, scheme+"://"); ok &&
+			strings.HasSuffix(rest, "."+host) && !strings.Contains(strings.TrimSuffix(rest, "."+host), "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// corsRouteEnabled 判断路径是否允许跨域访问。CORS 中间件在 pagePrefixHandler 之前执行，
+// 页面路径 /p/<slug>/rest 按去掉前缀后的 /rest 匹配，与默认页面的接口使用同样的规则
+func corsRouteEnabled(path string) bool {
+	if len(envCORSRoutes) == 0 {
+		return true
+	}
+	if _, rest, ok := splitPagePath(path); ok {
+		path = rest
+	}
+	for _, route := range envCORSRoutes {
+		if path == route || strings.HasPrefix(path, strings.TrimSuffix(route, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS 中间件：只对允许的来源和路径回显 Origin，其他跨域请求不带 CORS 响应头，预检请求直接拒绝
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(envCORSOrigins) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		wildcard := contains(envCORSOrigins, "*")
+		if !corsRouteEnabled(r.URL.Path) || !(wildcard || corsOriginAllowed(origin)) {
+			if preflight {
+				http.Error(w, "CORS origin not allowed", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// * 不能和凭证一起使用，明确列出的来源才回显并允许携带 cookie
+		if corsOriginAllowed(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if envCORSCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const forwardAuthProviderName = "proxy"
+
+// 反向代理认证配置，FORWARD_AUTH_HEADER 和 FORWARD_AUTH_TRUSTED_PROXIES 都设置时启用
+var (
+	envForwardAuthHeader       string       // 代理传递用户名的请求头，如 Remote-User
+	envForwardAuthGroupsHeader string       // 代理传递用户组的请求头（逗号分隔），如 Remote-Groups
+	envForwardAuthProxies      []*net.IPNet // 只信任来自这些地址的请求头
+	envForwardAuthRoleMapping  map[string]string
+	envForwardAuthDefaultRole  string
+)
+
+func forwardAuthEnabled() bool {
+	return envForwardAuthHeader != "" && len(envForwardAuthProxies) > 0
+}
+
+// parseCIDRs 解析逗号分隔的 CIDR 或 IP 列表，单个 IP 视为 /32 或 /128
+func parseCIDRs(key string, s string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			log.Printf("Invalid address %q in %s, ignored", item, key)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// trustedProxy 判断请求是否直接来自受信任的代理
+func trustedProxy(r *http.Request) bool {
+	return ipInNetworks(remoteIP(r), envForwardAuthProxies)
+}
+
+// authenticateForwarded 从受信任代理传递的请求头中获取用户。
+// 配置文件中的管理员和本地用户沿用自己的角色，其他用户按用户组映射角色并自动创建
+func authenticateForwarded(r *http.Request) (AuthInfo, bool) {
+	if !forwardAuthEnabled() {
+		return AuthInfo{}, false
+	}
+	username := strings.TrimSpace(r.Header.Get(envForwardAuthHeader))
+	if username == "" || !trustedProxy(r) {
+		return AuthInfo{}, false
+	}
+
+	if username == envUsername {
+		return AuthInfo{Username: username, Role: roleAdmin, Forwarded: true}, true
+	}
+	if account, ok := userStore.Get(username); ok && account.Provider == "" {
+		return AuthInfo{Username: username, Role: account.Role, Forwarded: true}, true
+	}
+
+	var groups []string
+	if envForwardAuthGroupsHeader != "" {
+		for _, group := range strings.Split(r.Header.Get(envForwardAuthGroupsHeader), ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+	role := mapRole(groups, envForwardAuthRoleMapping, envForwardAuthDefaultRole)
+	if role == "" {
+		log.Printf("Forward auth rejected: ip=%s user=%q reason=no_role", clientIP(r), username)
+		return AuthInfo{}, false
+	}
+	if _, err := userStore.PutExternal(username, role, forwardAuthProviderName); err != nil {
+		log.Printf("Forward auth rejected: ip=%s user=%q reason=%v", clientIP(r), username, err)
+		return AuthInfo{}, false
+	}
+	return AuthInfo{Username: username, Role: role, Forwarded: true}, true
+}
@@ -3,13 +3,23 @@ import type { Link, LoginCredentials, SortIndexUpdate, Config } from './types'
 
 const apiBase = import.meta.env.VITE_API_BASE
 
-class ApiError extends Error {
-  constructor(public status: number, message: string) {
+// 在 /p/<slug> 下打开时，导航接口都加上页面前缀
+const pagePrefix = window.location.pathname.match(/^\/p\/[^/]+/)?.[0] ?? ''
+
+export class ApiError extends Error {
+  constructor(public status: number, message: string, public headers?: Headers) {
     super(message)
     this.name = 'ApiError'
   }
 }
 
+// 会话 cookie 模式下，修改类请求需要带上 CSRF 令牌
+const csrfHeader = (method?: string): Record<string, string> => {
+  if (!method || ['GET', 'HEAD', 'OPTIONS'].includes(method.toUpperCase())) return {}
+  const csrf = document.cookie.match(/(?:^|;\s*)tiny_nav_csrf=([^;]*)/)?.[1]
+  return csrf ? { 'X-CSRF-Token': decodeURIComponent(csrf) } : {}
+}
+
 const apiFetch = async <T>(
   endpoint: string,
   options: RequestInit = {}
@@ -20,18 +30,21 @@ const apiFetch = async <T>(
   const headers: HeadersInit = {
     'Content-Type': 'application/json',
     ...(token ? { Authorization: token } : {}),
+    ...csrfHeader(options.method),
     ...options.headers
   }
 
-  const response = await fetch(`${apiBase}${endpoint}`, {
+  const prefix = endpoint.startsWith('/navigation') ? pagePrefix : ''
+  const response = await fetch(`${apiBase}${prefix}${endpoint}`, {
     ...options,
-    headers
+    headers,
+    credentials: store.config.sessionCookie ? 'include' : 'same-origin'
   })
 
   // 如果返回 401，清除 token
   if (response.status === 401) {
     store.logout()
-    throw new ApiError(401, 'Unauthorized')
+    throw new ApiError(401, 'Unauthorized', response.headers)
   }
 
   if (!response.ok) {
@@ -50,22 +63,56 @@ const apiFetch = async <T>(
   return { data, headers: responseHeaders }
 }
 
+// 当前数据版本，用于服务端校验并发修改
+const ifMatch = (): Record<string, string> => {
+  const store = useMainStore()
+  return store.lastModified > 0 ? { 'If-Match': `"${store.lastModified}"` } : {}
+}
+
+// 从 ETag 中解析新的数据版本
+const parseETag = (headers: Headers): number | null => {
+  const etag = headers.get('ETag')
+  if (!etag) return null
+  const value = Number(etag.replace(/^W\//, '').replace(/"/g, ''))
+  return Number.isNaN(value) ? null : value
+}
+
 export const api = {
-  async login(credentials: LoginCredentials): Promise<string> {
+  async login(credentials: LoginCredentials): Promise<string | null> {
     const { headers } = await apiFetch('/login', {
       method: 'POST',
       body: JSON.stringify(credentials)
     })
 
     // 从响应头获取 token
+    // 会话 cookie 模式下令牌在 HttpOnly cookie 中，响应头没有令牌
     const token = headers.get('Authorization')
-    if (!token) {
+    if (!token && !useMainStore().config.sessionCookie) {
       throw new Error('No token received')
     }
 
     return token
   },
 
+  // 用单点登录回调得到的一次性码换取令牌
+  async oidcToken(code: string): Promise<string | null> {
+    const { headers } = await apiFetch('/oidc/token', {
+      method: 'POST',
+      body: JSON.stringify({ code })
+    })
+    // 会话 cookie 模式下令牌在 HttpOnly cookie 中，响应头没有令牌
+    const token = headers.get('Authorization')
+    if (!token && !useMainStore().config.sessionCookie) {
+      throw new Error('No token received')
+    }
+    return token
+  },
+
+  // 注销当前登录令牌
+  async logout(): Promise<void> {
+    await apiFetch('/logout', { method: 'POST' })
+  },
+
   async getNavigation(): Promise<{ links: Link[], categories: string[], lastModified: number }> {
     const { data } = await apiFetch<{ links: Link[], categories: string[], lastModified: number }>('/navigation')
     return data
@@ -77,42 +124,50 @@ export const api = {
   },
 
   async addLink(link: Link): Promise<void> {
-    apiFetch('/navigation/add', {
+    await apiFetch('/navigation/add', {
       method: 'POST',
+      headers: ifMatch(),
       body: JSON.stringify(link)
     })
   },
 
-  async updateLink(index: number, link: Link): Promise<void> {
-    apiFetch(`/navigation/update/${index}`, {
+  async updateLink(id: string, link: Link): Promise<void> {
+    await apiFetch(`/navigation/links/${encodeURIComponent(id)}`, {
       method: 'PUT',
+      headers: ifMatch(),
       body: JSON.stringify(link)
     })
   },
 
-  async deleteLink(index: number): Promise<void> {
-    apiFetch(`/navigation/delete/${index}`, {
-      method: 'DELETE'
+  async deleteLink(id: string): Promise<void> {
+    await apiFetch(`/navigation/links/${encodeURIComponent(id)}`, {
+      method: 'DELETE',
+      headers: ifMatch()
     })
   },
 
+  // 返回缓存的图标地址 /icons/<hash>
   async getWebsiteIcon(url: string): Promise<string> {
-    const { data } = await apiFetch<{ iconData: string }>(`/get-icon?url=${encodeURIComponent(url)}`)
-    return data.iconData
+    const { data } = await apiFetch<{ icon: string }>(`/get-icon?url=${encodeURIComponent(url)}`)
+    return data.icon
   },
 
-  async updateSortIndices(updates: SortIndexUpdate[]): Promise<void> {
-    apiFetch('/navigation/sort', {
+  async updateSortIndices(updates: SortIndexUpdate[]): Promise<number | null> {
+    const { headers } = await apiFetch('/navigation/sort', {
       method: 'PUT',
+      headers: ifMatch(),
       body: JSON.stringify({ updates })
     })
+    return parseETag(headers)
   },
 
-  async updateCategories(categories: string[]): Promise<void> {
-    apiFetch('/navigation/categories', {
+  async updateCategories(categories: string[]): Promise<number | null> {
+    const { headers } = await apiFetch('/navigation/categories', {
       method: 'PUT',
+      headers: ifMatch(),
       body: JSON.stringify({ categories })
     })
+    return parseETag(headers)
   },
 
   async getConfig(): Promise<Config> {
@@ -120,8 +175,8 @@ export const api = {
     return data
   },
 
-  async validateToken(): Promise<{ status: string }> {
-    const { data } = await apiFetch<{ status: string }>('/validate')
+  async validateToken(): Promise<{ status: string; username: string; role: string }> {
+    const { data } = await apiFetch<{ status: string; username: string; role: string }>('/validate')
     return data
   },
 }
@@ -1,17 +1,21 @@
 export interface Link {
+  id?: string
   name: string
   url: string
   icon: string
   category: string
   sortIndex: number
+  board?: 'shared' | 'personal' // 所属的导航板，personal 只有自己可见
 }
 
 export interface LoginCredentials {
   username: string
   password: string
+  totpCode?: string // 启用两步验证时的验证码或恢复码
 }
 
 export interface SortIndexUpdate {
+  id?: string
   index: number
   sortIndex: number
   category?: string
@@ -20,4 +24,7 @@ export interface SortIndexUpdate {
 export interface Config {
   enableNoAuth: boolean
   enableNoAuthView: boolean
+  enableOIDC?: boolean
+  forwardAuth?: boolean
+  sessionCookie?: boolean
 }
@@ -4,6 +4,7 @@ import { api } from '@/api'
 
 interface State {
   token: string | null
+  username: string
   links: Link[]
   categories: string[]
   lastModified: number
@@ -13,6 +14,7 @@ interface State {
 export const useMainStore = defineStore('main', {
   state: (): State => ({
     token: null,
+    username: '',
     links: [],
     categories: [],
     lastModified: -1,
@@ -36,16 +38,19 @@ export const useMainStore = defineStore('main', {
     },
     logout() {
       this.token = null
+      this.username = ''
       this.links = []
       this.categories = []
       this.lastModified = -1
     },
     // 验证 token 是否有效
     async validateToken(): Promise<boolean> {
-      if (!this.token) return false
+      // 反向代理认证和会话 cookie 模式下没有令牌，由请求头或 cookie 认证
+      if (!this.token && !this.config.forwardAuth && !this.config.sessionCookie) return false
 
       try {
-        await api.validateToken()
+        const { username } = await api.validateToken()
+        this.username = username || ''
         return true
       } catch (error) {
         // 如果token无效，清除存储的token
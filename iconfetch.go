@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/net/html"
+	"image"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 抓取图标的限制
+const (
+	iconFetchTimeout        = 15 * time.Second // 整个抓取过程（网页和所有候选图标）
+	iconRequestTimeout      = 5 * time.Second  // 单个请求
+	iconDialTimeout         = 3 * time.Second
+	iconFetchMaxRedirects   = 3
+	iconFetchMaxSize        = 2 << 20 // 单个响应的最大字节数
+	iconFetchMaxCandidates  = 12      // 最多尝试的候选图标数量
+	iconSVGSortSize         = 1 << 16 // SVG 可以任意缩放，排序时视为比所有位图都大
+	iconFetchUserAgent      = "Mozilla/5.0 (iPhone; CPU iPhone OS 10_0 like Mac OS X) AppleWebKit/602.1.38 (KHTML, like Gecko) Version/10.0 Mobile/14A5297c Safari/602.1"
+	iconFetchBlockedMessage = "Fetching icons from private or reserved addresses is not allowed"
+)
+
+var envIconFetchAllowedNetworks []*net.IPNet // 允许抓取图标的内网地址段，如 Tailscale 的 100.64.0.0/10
+
+// iconFetchBlockedNetworks 不允许抓取的地址：本机、内网、链路本地（含云服务器元数据地址）、运营商 NAT 和保留地址，
+// 以及内嵌 IPv4 地址的 NAT64、Teredo（2001::/32）和 6to4（2002::/16），防止经由隧道地址访问被拦截的 IPv4 地址
+var iconFetchBlockedNetworks = parseCIDRs("iconFetchBlockedNetworks", strings.Join([]string{
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24",
+	"203.0.113.0/24", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "64:ff9b::/96", "100::/64", "2001::/32", "2001:db8::/32", "2002::/16",
+	"fc00::/7", "fe80::/10", "ff00::/8",
+}, ","))
+
+var (
+	errIconFetchBlocked  = errors.New("blocked address")
+	errIconFetchTooLarge = errors.New("response too large")
+)
+
+// iconFetchAllowed 判断是否允许连接该地址，允许列表优先
+func iconFetchAllowed(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, ipNet := range envIconFetchAllowedNetworks {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	for _, ipNet := range iconFetchBlockedNetworks {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// iconFetcher 一次图标抓取使用的 HTTP 客户端。候选图标的错误会被忽略，
+// 所以被拦截或超出大小的请求记录在这里，用于返回明确的错误
+type iconFetcher struct {
+	ctx context.Context // 整个抓取过程的期限，超时或返回后取消所有未完成的请求
+
+	mu       sync.Mutex
+	blocked  string // 被拦截的地址
+	tooLarge bool
+}
+
+func (f *iconFetcher) recordBlocked(address string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.blocked == "" {
+		f.blocked = address
+	}
+}
+
+func (f *iconFetcher) recordTooLarge() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tooLarge = true
+}
+
+// client 返回受保护的 HTTP 客户端：在建立连接时检查实际连接的 IP（包括重定向后的地址，
+// 防止 DNS 重绑定），不使用环境变量中的代理，限制重定向次数、超时和响应大小
+func (f *iconFetcher) client() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: iconDialTimeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !iconFetchAllowed(ip) {
+				f.recordBlocked(host)
+				return errIconFetchBlocked
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   iconDialTimeout,
+		ResponseHeaderTimeout: iconRequestTimeout,
+		MaxIdleConnsPerHost:   2,
+		IdleConnTimeout:       iconFetchTimeout,
+	}
+	jar, _ := cookiejar.New(nil) // 部分网站在重定向时设置 cookie
+	return &http.Client{
+		Timeout:   iconRequestTimeout,
+		Jar:       jar,
+		Transport: &iconFetchTransport{transport: transport, fetcher: f},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= iconFetchMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", iconFetchMaxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// iconFetchTransport 设置请求头、使用整个抓取过程的期限并限制响应大小
+type iconFetchTransport struct {
+	transport http.RoundTripper
+	fetcher   *iconFetcher
+}
+
+func (t *iconFetchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(t.fetcher.ctx)
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("User-Agent", iconFetchUserAgent)
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength > iconFetchMaxSize {
+		resp.Body.Close()
+		t.fetcher.recordTooLarge()
+		return nil, errIconFetchTooLarge
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: iconFetchMaxSize, fetcher: t.fetcher}
+	return resp, nil
+}
+
+// limitedBody 读取超过 remaining 字节时返回错误，而不是像 io.LimitReader 那样静默截断
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	fetcher   *iconFetcher
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		b.fetcher.recordTooLarge()
+		return 0, errIconFetchTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		b.fetcher.recordTooLarge()
+		return n, errIconFetchTooLarge
+	}
+	return n, err
+}
+
+// checkIconURL 在抓取前检查网址，返回补全协议后的网址。
+// 主机名解析出的任一地址不允许访问时直接拒绝
+func checkIconURL(ctx context.Context, rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if !strings.HasPrefix(rawURL, "http:") && !strings.HasPrefix(rawURL, "https:") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return "", newRequestError(http.StatusBadRequest, "Invalid URL")
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(ips) == 0 {
+		return "", newRequestError(http.StatusBadRequest, "Cannot resolve host %s", u.Hostname())
+	}
+	for _, ip := range ips {
+		if !iconFetchAllowed(ip.IP) {
+			return "", newRequestError(http.StatusForbidden, iconFetchBlockedMessage)
+		}
+	}
+	return u.String(), nil
+}
+
+// fetchedIcon 抓取到的候选图标
+type fetchedIcon struct {
+	URL    string
+	Data   []byte
+	Width  int
+	Height int
+}
+
+// fetchIcons 用受保护的客户端抓取网址的图标，按尺寸从大到小排列。
+// 网页和图标都由 iconFetcher 请求，besticon 只用于解码 ICO
+func fetchIcons(rawURL string) ([]fetchedIcon, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), iconFetchTimeout)
+	defer cancel()
+
+	siteURL, err := checkIconURL(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher := &iconFetcher{ctx: ctx}
+	client := fetcher.client()
+	links, err := fetcher.iconLinks(client, siteURL)
+	if err != nil {
+		return nil, newRequestError(http.StatusBadRequest, "Invalid URL")
+	}
+	icons := fetcher.fetchCandidates(client, links)
+	// 超时后 ctx 被取消，剩余的请求会立即失败
+	if ctx.Err() != nil && len(icons) == 0 {
+		return nil, newRequestError(http.StatusGatewayTimeout, "Timed out fetching icons")
+	}
+
+	fetcher.mu.Lock()
+	blocked, tooLarge := fetcher.blocked, fetcher.tooLarge
+	fetcher.mu.Unlock()
+	if len(icons) > 0 {
+		return icons, nil
+	}
+	switch {
+	case blocked != "":
+		log.Printf("Icon fetch blocked: url=%s address=%s", siteURL, blocked)
+		return nil, newRequestError(http.StatusForbidden, iconFetchBlockedMessage)
+	case tooLarge:
+		return nil, newRequestError(http.StatusBadGateway, "Response exceeds %d bytes", iconFetchMaxSize)
+	}
+	log.Printf("No icons from: %s", siteURL)
+	return nil, newRequestError(http.StatusNotFound, "No icons")
+}
+
+// get 请求 u，返回响应内容和重定向后的地址
+func (f *iconFetcher) get(client *http.Client, u string) ([]byte, *url.URL, error) {
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, resp.Request.URL, nil
+}
+
+// iconDefaultPaths 网页中没有声明图标时也会尝试的地址
+var iconDefaultPaths = []string{"/favicon.ico", "/apple-touch-icon.png", "/apple-touch-icon-precomposed.png"}
+
+// iconLinks 返回候选图标地址：网页中 <link rel="icon|apple-touch-icon|apple-touch-icon-precomposed"> 声明的图标
+// 和常见的默认地址。网页无法访问时只使用默认地址
+func (f *iconFetcher) iconLinks(client *http.Client, siteURL string) ([]string, error) {
+	base, err := url.Parse(siteURL)
+	if err != nil {
+		return nil, err
+	}
+	var hrefs []string
+	if body, finalURL, err := f.get(client, siteURL); err == nil {
+		base = finalURL
+		var baseHref string
+		baseHref, hrefs = parseIconLinks(body)
+		if baseHref != "" {
+			if u, err := base.Parse(baseHref); err == nil {
+				base = u
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	var links []string
+	for _, href := range append(hrefs, iconDefaultPaths...) {
+		u, err := base.Parse(strings.TrimSpace(href))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || seen[u.String()] {
+			continue
+		}
+		seen[u.String()] = true
+		links = append(links, u.String())
+		if len(links) >= iconFetchMaxCandidates {
+			break
+		}
+	}
+	return links, nil
+}
+
+// parseIconLinks 从网页中找出 <base href> 和图标的 <link>
+func parseIconLinks(body []byte) (string, []string) {
+	var baseHref string
+	var hrefs []string
+	tokenizer := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			return baseHref, hrefs
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		token := tokenizer.Token()
+		attrs := make(map[string]string)
+		for _, attr := range token.Attr {
+			attrs[strings.ToLower(attr.Key)] = attr.Val
+		}
+		switch token.Data {
+		case "base":
+			if baseHref == "" {
+				baseHref = attrs["href"]
+			}
+		case "link":
+			if attrs["href"] == "" {
+				continue
+			}
+			for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+				if rel == "icon" || rel == "apple-touch-icon" || rel == "apple-touch-icon-precomposed" {
+					hrefs = append(hrefs, attrs["href"])
+					break
+				}
+			}
+		case "body":
+			return baseHref, hrefs // 图标只在 <head> 中声明
+		}
+	}
+}
+
+// fetchCandidates 并发下载候选图标，丢弃无法识别的内容，按尺寸从大到小、体积从小到大排列（SVG 优先）
+func (f *iconFetcher) fetchCandidates(client *http.Client, links []string) []fetchedIcon {
+	results := make(chan *fetchedIcon, len(links))
+	for _, link := range links {
+		go func(link string) {
+			results <- f.fetchCandidate(client, link)
+		}(link)
+	}
+	var icons []fetchedIcon
+	for range links {
+		if icon := <-results; icon != nil {
+			icons = append(icons, *icon)
+		}
+	}
+	sort.SliceStable(icons, func(i, j int) bool {
+		a, b := icons[i], icons[j]
+		if a.Width != b.Width {
+			return a.Width > b.Width
+		}
+		if len(a.Data) != len(b.Data) {
+			return len(a.Data) < len(b.Data)
+		}
+		return a.URL < b.URL
+	})
+	return icons
+}
+
+func (f *iconFetcher) fetchCandidate(client *http.Client, link string) *fetchedIcon {
+	data, _, err := f.get(client, link)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	if iconContentType(data) == "image/svg+xml" {
+		return &fetchedIcon{URL: link, Data: data, Width: iconSVGSortSize, Height: iconSVGSortSize}
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 1 || config.Height <= 1 {
+		return nil
+	}
+	return &fetchedIcon{URL: link, Data: data, Width: config.Width, Height: config.Height}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	_ "github.com/mat/besticon/v3/ico"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultIconSize = 128
+	minIconSize     = 16
+	maxIconSize     = 512
+	iconMaxPixels   = 4096 * 4096 // 解码前检查尺寸，防止解压炸弹
+)
+
+var envIconSize int // 位图图标缩小到的最大边长（像素）
+
+// normalizeIcon 处理抓取或导入的图标：位图解码后缩小到 envIconSize 并重新编码为 PNG
+// （同时去掉元数据和多余的帧），SVG 去掉脚本、事件和外部引用
+func normalizeIcon(data []byte) ([]byte, error) {
+	if iconContentType(data) == "image/svg+xml" {
+		return sanitizeSVG(data)
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported icon format: %v", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > iconMaxPixels {
+		return nil, fmt.Errorf("icon too large: %dx%d", config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s icon: %v", format, err)
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > envIconSize || height > envIconSize {
+		// 按比例缩小，长边为 envIconSize
+		if width >= height {
+			width, height = envIconSize, max(1, height*envIconSize/width)
+		} else {
+			width, height = max(1, width*envIconSize/height), envIconSize
+		}
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+const (
+	svgNamespace   = "http://www.w3.org/2000/svg"
+	xlinkNamespace = "http://www.w3.org/1999/xlink"
+	xmlNamespace   = "http://www.w3.org/XML/1998/namespace"
+)
+
+// svgAllowedElements 允许保留的 SVG 元素（只包含图形、渐变、滤镜等），其他元素连同子元素一起删除。
+// 不包含 style、a、image、foreignObject、script 和动画元素：SVG 代码通过 v-html 插入页面，
+// 这些元素可以影响整个页面的样式、加载外部内容或执行脚本
+var svgAllowedElements = canonicalNames(
+	"svg", "g", "defs", "symbol", "use", "title", "desc",
+	"path", "rect", "circle", "ellipse", "line", "polyline", "polygon",
+	"text", "tspan", "textPath",
+	"linearGradient", "radialGradient", "stop", "clipPath", "mask", "pattern", "marker",
+	"filter", "feBlend", "feColorMatrix", "feComponentTransfer", "feComposite", "feDisplacementMap",
+	"feDropShadow", "feFlood", "feFuncA", "feFuncB", "feFuncG", "feFuncR", "feGaussianBlur",
+	"feMerge", "feMergeNode", "feMorphology", "feOffset", "feTurbulence",
+)
+
+// svgAllowedAttrs 允许保留的属性（几何和外观），也用于过滤 style 属性中的 CSS 属性。
+// href 和 xlink:href 单独处理，只允许 #id 形式的内部引用
+var svgAllowedAttrs = canonicalNames(
+	"id", "x", "y", "x1", "y1", "x2", "y2", "cx", "cy", "r", "rx", "ry", "fx", "fy", "dx", "dy",
+	"width", "height", "d", "points", "viewBox", "preserveAspectRatio", "transform", "version",
+	"fill", "fill-opacity", "fill-rule", "stroke", "stroke-width", "stroke-linecap", "stroke-linejoin",
+	"stroke-miterlimit", "stroke-dasharray", "stroke-dashoffset", "stroke-opacity", "opacity", "color",
+	"clip-path", "clip-rule", "mask", "filter", "visibility", "display", "vector-effect", "shape-rendering",
+	"gradientUnits", "gradientTransform", "spreadMethod", "offset", "stop-color", "stop-opacity",
+	"patternUnits", "patternContentUnits", "patternTransform", "maskUnits", "maskContentUnits",
+	"clipPathUnits", "filterUnits", "primitiveUnits",
+	"marker-start", "marker-mid", "marker-end", "markerWidth", "markerHeight", "markerUnits", "refX", "refY", "orient",
+	"font-family", "font-size", "font-weight", "font-style", "text-anchor", "dominant-baseline",
+	"letter-spacing", "rotate", "textLength", "lengthAdjust", "startOffset",
+	"in", "in2", "result", "stdDeviation", "mode", "operator", "k1", "k2", "k3", "k4", "values", "type",
+	"flood-color", "flood-opacity", "tableValues", "slope", "intercept", "amplitude", "exponent",
+	"baseFrequency", "numOctaves", "seed", "stitchTiles", "scale", "xChannelSelector", "yChannelSelector", "radius",
+)
+
+// canonicalNames 返回小写名称到标准写法的映射，输出时使用标准写法（SVG 区分大小写，如 viewBox）
+func canonicalNames(names ...string) map[string]string {
+	m := make(map[string]string, len(names))
+	for _, name := range names {
+		m[strings.ToLower(name)] = name
+	}
+	return m
+}
+
+var cssURLPattern = regexp.MustCompile(`(?i)url\(\s*(['"]?)([^'")]*)(['"]?)\s*\)`)
+
+// sanitizeSVGValue 把指向外部的 url() 替换为 none，只保留 url(#id) 形式的内部引用
+func sanitizeSVGValue(value string) string {
+	return cssURLPattern.ReplaceAllStringFunc(value, func(m string) string {
+		target := strings.TrimSpace(cssURLPattern.FindStringSubmatch(m)[2])
+		if strings.HasPrefix(target, "#") {
+			return m
+		}
+		return "none"
+	})
+}
+
+// sanitizeSVGStyle 只保留 style 属性中允许的 CSS 属性（与 svgAllowedAttrs 相同），
+// 去掉 position 等可以让图标覆盖页面的属性
+func sanitizeSVGStyle(style string) string {
+	var decls []string
+	for _, decl := range strings.Split(style, ";") {
+		name, value, ok := strings.Cut(decl, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, allowed := svgAllowedAttrs[name]; !ok || !allowed || name == "id" {
+			continue
+		}
+		decls = append(decls, name+":"+sanitizeSVGValue(strings.TrimSpace(value)))
+	}
+	return strings.Join(decls, ";")
+}
+
+// sanitizeSVGAttr 返回输出时的属性名和值，不在允许列表中的属性返回 false
+func sanitizeSVGAttr(attr xml.Attr) (string, string, bool) {
+	name := strings.ToLower(attr.Name.Local)
+	value := attr.Value
+	if strings.Contains(strings.ToLower(strings.Join(strings.Fields(value), "")), "javascript:") {
+		return "", "", false
+	}
+	switch attr.Name.Space {
+	case "":
+	case xlinkNamespace, "xlink":
+		if name != "href" {
+			return "", "", false
+		}
+	case xmlNamespace, "xml":
+		if name == "space" {
+			return "xml:space", value, true
+		}
+		return "", "", false
+	default:
+		return "", "", false
+	}
+
+	switch name {
+	case "href":
+		// xlink:href 统一输出为 href，只允许引用文档内的元素
+		return "href", value, strings.HasPrefix(strings.TrimSpace(value), "#")
+	case "style":
+		style := sanitizeSVGStyle(value)
+		return "style", style, style != ""
+	}
+	canonical, ok := svgAllowedAttrs[name]
+	if !ok {
+		return "", "", false
+	}
+	return canonical, sanitizeSVGValue(value), true
+}
+
+// sanitizeSVG 逐个读取 XML 节点重新输出 SVG，只保留 SVG 命名空间中允许的元素和属性，
+// 删除注释、处理指令和 DOCTYPE（防止实体扩展）。根元素必须是 <svg>，输出总是以 <svg 开头
+func sanitizeSVG(data []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+
+	var out bytes.Buffer
+	var stack []string // 已输出的元素名，被删除的元素记为空字符串
+	skip := 0          // 大于 0 时在被删除元素的内部
+	sawRoot := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid svg: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			// 没有声明命名空间的元素（如直接写在页面中的 <svg>）按 SVG 处理
+			canonical, allowed := svgAllowedElements[strings.ToLower(t.Name.Local)]
+			allowed = allowed && (t.Name.Space == "" || t.Name.Space == svgNamespace)
+			if len(stack) == 0 {
+				if sawRoot || !allowed || canonical != "svg" {
+					return nil, errors.New("invalid svg: root element must be <svg>")
+				}
+				sawRoot = true
+			}
+			if skip > 0 || !allowed {
+				skip++
+				stack = append(stack, "")
+				continue
+			}
+			stack = append(stack, canonical)
+			out.WriteString("<" + canonical)
+			if len(stack) == 1 {
+				out.WriteString(` xmlns="` + svgNamespace + `"`)
+			}
+			for _, attr := range t.Attr {
+				if name, value, ok := sanitizeSVGAttr(attr); ok {
+					out.WriteString(" " + name + `="`)
+					xml.EscapeText(&out, []byte(value))
+					out.WriteString(`"`)
+				}
+			}
+			out.WriteString(">")
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, errors.New("invalid svg: unexpected end tag")
+			}
+			name := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if name == "" {
+				skip--
+				continue
+			}
+			out.WriteString("</" + name + ">")
+		case xml.CharData:
+			if skip > 0 || len(stack) == 0 {
+				continue
+			}
+			xml.EscapeText(&out, t)
+		}
+	}
+	if !sawRoot || len(stack) != 0 {
+		return nil, errors.New("invalid svg: incomplete document")
+	}
+	return out.Bytes(), nil
+}
+
+// normalizeLinkIcons 处理链接中的图标：data URI 图标存入图标缓存并替换为引用；
+// 其他含有标签的图标都按 SVG 代码处理，只保留允许的元素和属性，无法处理的会被清空。
+// 处理后的 SVG 代码以 <svg 开头，前端只对以 <svg 开头的图标使用 v-html。返回是否有修改
+func normalizeLinkIcons(nav *Navigation) bool {
+	changed := false
+	for i := range nav.Links {
+		link := &nav.Links[i]
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(link.Icon)), "data:") {
+			if storeInlineIcon(link) {
+				changed = true
+			}
+			continue
+		}
+		if !strings.Contains(link.Icon, "<") {
+			continue // 图标地址，前端用 <img> 显示
+		}
+		icon := ""
+		if data, err := sanitizeSVG([]byte(link.Icon)); err != nil {
+			log.Printf("Removed invalid svg icon of %s: %v", link.Url, err)
+		} else {
+			icon = string(data)
+		}
+		if icon != link.Icon {
+			link.Icon = icon
+			changed = true
+		}
+	}
+	return changed
+}
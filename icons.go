@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	iconDirName   = "icons"
+	iconURLPrefix = "/icons/"
+	iconSitesFile = "sites.json"
+	iconSiteTTL   = 7 * 24 * time.Hour // 同一网址在此期间内不重新抓取图标
+	iconMaxAge    = 365 * 24 * 60 * 60 // 图标地址随内容变化，浏览器可以一直缓存
+)
+
+var validIconHash = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+var iconCache *IconCache
+
+// IconCache 按内容的 SHA-256 保存图标文件（dataDir/icons/<hash>），相同的图标只保存一份。
+// 链接中只保存 /icons/<hash> 引用
+type IconCache struct {
+	dir string
+
+	mu    sync.Mutex
+	sites map[string]iconSite // 按网址记录最近一次抓取到的图标
+}
+
+type iconSite struct {
+	Hash      string `json:"hash"`
+	FetchedAt int64  `json:"fetchedAt"`
+}
+
+func NewIconCache(dir string) *IconCache {
+	c := &IconCache{
+		dir:   dir,
+		sites: make(map[string]iconSite),
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, iconSitesFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading icon index: %v", err)
+		}
+		return c
+	}
+	if err := json.Unmarshal(data, &c.sites); err != nil {
+		log.Printf("Error unmarshaling icon index: %v", err)
+		c.sites = make(map[string]iconSite)
+	}
+	return c
+}
+
+// Put 处理图标（见 normalizeIcon）后保存，返回引用地址。内容相同的图标已存在时直接返回
+func (c *IconCache) Put(data []byte) (string, error) {
+	data, err := normalizeIcon(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := filepath.Join(c.dir, hash)
+	if _, err := os.Stat(path); err == nil {
+		return iconURLPrefix + hash, nil
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to save icon: %v", err)
+	}
+	return iconURLPrefix + hash, nil
+}
+
+// Lookup 返回网址最近抓取到的图标，超过 iconSiteTTL 或文件已不存在时返回 false
+func (c *IconCache) Lookup(site string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.sites[site]
+	if !ok || time.Since(time.Unix(entry.FetchedAt, 0)) > iconSiteTTL {
+		return "", false
+	}
+	if _, err := os.Stat(filepath.Join(c.dir, entry.Hash)); err != nil {
+		return "", false
+	}
+	return iconURLPrefix + entry.Hash, true
+}
+
+// Remember 记录网址抓取到的图标
+func (c *IconCache) Remember(site string, ref string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sites[site] = iconSite{Hash: strings.TrimPrefix(ref, iconURLPrefix), FetchedAt: time.Now().Unix()}
+	data, err := json.MarshalIndent(c.sites, "", "  ")
+	if err != nil {
+		log.Printf("Error marshaling icon index: %v", err)
+		return
+	}
+	if err := writeFileAtomic(filepath.Join(c.dir, iconSitesFile), data, 0644); err != nil {
+		log.Printf("Error saving icon index: %v", err)
+	}
+}
+
+// decodeDataURI 解析 data:image/... 形式的图标，支持 base64 和 URL 编码
+func decodeDataURI(uri string) ([]byte, bool) {
+	if len(uri) < 5 || !strings.EqualFold(uri[:5], "data:") {
+		return nil, false
+	}
+	meta, payload, ok := strings.Cut(uri[5:], ",")
+	if !ok {
+		return nil, false
+	}
+	meta = strings.ToLower(meta)
+	mediaType, _, _ := strings.Cut(meta, ";")
+	if !strings.HasPrefix(mediaType, "image/") {
+		return nil, false
+	}
+
+	if strings.HasSuffix(meta, ";base64") {
+		payload = strings.Join(strings.Fields(payload), "")
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		}
+		return data, err == nil && len(data) > 0
+	}
+	data, err := url.PathUnescape(payload)
+	return []byte(data), err == nil && data != ""
+}
+
+// storeInlineIcon 把链接中内嵌的 data URI 图标存入图标缓存并替换为引用，返回是否有修改
+func storeInlineIcon(link *Link) bool {
+	if iconCache == nil {
+		return false
+	}
+	data, ok := decodeDataURI(link.Icon)
+	if !ok {
+		return false
+	}
+	ref, err := iconCache.Put(data)
+	if err != nil {
+		log.Printf("Failed to store icon of %s: %v", link.Url, err)
+		return false
+	}
+	link.Icon = ref
+	return true
+}
+
+// iconContentType 根据内容判断图标类型，SVG 需要单独识别
+func iconContentType(data []byte) string {
+	contentType := http.DetectContentType(data)
+	if strings.HasPrefix(contentType, "text/") && bytes.Contains(bytes.ToLower(data), []byte("<svg")) {
+		return "image/svg+xml"
+	}
+	return contentType
+}
+
+// iconHandler GET /icons/<hash> 返回缓存的图标。
+// 图标通过 <img> 加载，无法带 Authorization 请求头，因此不需要认证；地址由内容哈希生成，无法枚举
+func iconHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hash := strings.TrimPrefix(r.URL.Path, iconURLPrefix)
+	if !validIconHash.MatchString(hash) {
+		http.NotFound(w, r)
+		return
+	}
+	path := filepath.Join(iconCache.dir, hash)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", iconContentType(data))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", iconMaxAge))
+	w.Header().Set("ETag", `"`+hash+`"`)
+	// 直接打开 SVG 图标时不执行其中的脚本
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(data))
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	loginFreeAttemptsPerIP   = 5                // 每个 IP 连续失败多少次后开始锁定
+	loginFreeAttemptsPerUser = 10               // 每个用户名连续失败多少次后开始锁定
+	loginBaseLockout         = time.Second      // 第一次锁定时长，之后每次失败翻倍
+	loginMaxLockout          = 15 * time.Minute // 最长锁定时长
+	loginAttemptWindow       = 15 * time.Minute // 超过该时间没有失败且未锁定则清除记录，失败次数重新计算
+	loginMaxEntries          = 10000            // 每种记录（IP、用户名）最多保存的数量，防止随机用户名耗尽内存
+)
+
+var loginLimiter = NewLoginLimiter()
+
+// loginAttempts 某个 IP 或用户名的连续失败记录
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// LoginLimiter 按客户端 IP 和用户名记录登录失败次数，超过阈值后按指数退避锁定
+type LoginLimiter struct {
+	mu     sync.Mutex
+	byIP   map[string]*loginAttempts
+	byUser map[string]*loginAttempts
+}
+
+func NewLoginLimiter() *LoginLimiter {
+	return &LoginLimiter{
+		byIP:   make(map[string]*loginAttempts),
+		byUser: make(map[string]*loginAttempts),
+	}
+}
+
+// Locked 返回 IP 或用户名剩余的锁定时间，未锁定时返回 0
+func (l *LoginLimiter) Locked(ip string, username string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var remaining time.Duration
+	for _, a := range []*loginAttempts{l.byIP[ip], l.byUser[username]} {
+		if a != nil && a.lockedUntil.After(now) {
+			if d := a.lockedUntil.Sub(now); d > remaining {
+				remaining = d
+			}
+		}
+	}
+	return remaining
+}
+
+// Fail 记录一次失败，返回本次失败后的锁定时长
+func (l *LoginLimiter) Fail(ip string, username string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.cleanup(now)
+	lockIP := l.fail(l.byIP, ip, loginFreeAttemptsPerIP, now)
+	lockUser := l.fail(l.byUser, username, loginFreeAttemptsPerUser, now)
+	if lockUser > lockIP {
+		return lockUser
+	}
+	return lockIP
+}
+
+// Succeed 登录成功后清除 IP 和用户名的失败记录
+func (l *LoginLimiter) Succeed(ip string, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.byIP, ip)
+	delete(l.byUser, username)
+}
+
+func (l *LoginLimiter) fail(m map[string]*loginAttempts, key string, freeAttempts int, now time.Time) time.Duration {
+	a := m[key]
+	if a == nil {
+		if len(m) >= loginMaxEntries && !evictOldestUnlocked(m, now) {
+			return 0 // 记录都在锁定中，不再记录新的 key，另一种记录（IP 或用户名）仍然生效
+		}
+		a = &loginAttempts{}
+		m[key] = a
+	}
+	a.failures++
+	a.lastFailure = now
+	if a.failures < freeAttempts {
+		return 0
+	}
+
+	lockout := loginBaseLockout
+	for i := freeAttempts; i < a.failures && lockout < loginMaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > loginMaxLockout {
+		lockout = loginMaxLockout
+	}
+	a.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+// cleanup 清除已解除锁定且 loginAttemptWindow 内没有失败的记录，避免内存无限增长
+func (l *LoginLimiter) cleanup(now time.Time) {
+	for _, m := range []map[string]*loginAttempts{l.byIP, l.byUser} {
+		for k, a := range m {
+			if !a.lockedUntil.After(now) && now.Sub(a.lastFailure) > loginAttemptWindow {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+// evictOldestUnlocked 删除最早失败且未在锁定中的记录，全部都在锁定中时返回 false
+func evictOldestUnlocked(m map[string]*loginAttempts, now time.Time) bool {
+	oldestKey := ""
+	var oldest *loginAttempts
+	for k, a := range m {
+		if a.lockedUntil.After(now) {
+			continue
+		}
+		if oldest == nil || a.lastFailure.Before(oldest.lastFailure) {
+			oldestKey, oldest = k, a
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(m, oldestKey)
+	return true
+}
+
+var envTrustedProxies []*net.IPNet // 受信任的反向代理，只有来自这些地址的请求才采用 X-Forwarded-For 和 X-Real-IP
+
+// remoteIP 返回直接连接的地址
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ipInNetworks(s string, networks []*net.IPNet) bool {
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range networks {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 返回请求的客户端 IP。直接连接的地址是受信任的代理时，从 X-Forwarded-For 中
+// 从右往左取第一个不是受信任代理的地址（左边的地址可以由客户端伪造），没有时使用 X-Real-IP
+func clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !ipInNetworks(ip, envTrustedProxies) {
+		return ip
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !ipInNetworks(hop, envTrustedProxies) {
+				break
+			}
+		}
+		return ip
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return ip
+}
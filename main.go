@@ -2,20 +2,25 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"embed"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/mat/besticon/v3/besticon"
 	"gopkg.in/ini.v1"
-	"io"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -23,33 +28,50 @@ import (
 var embeddedFiles embed.FS
 
 const (
-	defaultExpireTime  = 30 * 24 * time.Hour // token 过期时间
-	defaulttokenCount  = 10                  // 最多存储的 token 数量
-	dataDir            = "data"
-	tokenFileName      = "tokens.json"
-	navigationFileName = "navigation.json"
-	configFileName     = "config.ini"
+	defaultExpireTime = 30 * 24 * time.Hour // token 过期时间
+	defaulttokenCount = 10                  // 最多存储的 token 数量
+	tokenTouchPeriod  = time.Minute         // 同一 token 两次续期的最小间隔
+	tokenFlushPeriod  = 5 * time.Minute     // 续期后写回存储的间隔
+	dataDir           = "data"
+	configFileName    = "config.ini"
 )
 
 var tokenStore *TokenStore
 
-var envPort string           // 监听端口
-var envUsername string       // 登录用户名
-var envPassword string       // 登录密码
-var envEnableNoAuth bool     // 是否启用无用户密码模式
-var envEnableNoAuthView bool // 是否启用无用户密码浏览模式
+var envPort string                  // 监听端口
+var envUsername string              // 登录用户名
+var envPassword string              // 登录密码
+var envPasswordHash string          // 登录密码的 bcrypt/argon2id 哈希，优先于明文密码
+var envEnableNoAuth bool            // 是否启用无用户密码模式
+var envEnableNoAuthView bool        // 是否启用无用户密码浏览模式
+var envStorageDriver string         // 存储驱动: json 或 sqlite
+var envMaxRevisions int             // 保留的历史版本数量
+var restoreRevisionID int64         // 命令行指定要恢复的历史版本
+var restorePageSlug string          // 要恢复历史版本的页面，为空表示第一个页面
+var envBackupInterval time.Duration // 自动备份间隔，0 表示不备份
+var envBackupDir string             // 备份目录
+var envBackupKeep int               // 保留的备份数量
 
 type User struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	TOTPCode string `json:"totpCode"` // 启用两步验证时的验证码或恢复码
 }
 
 type Link struct {
+	ID        string `json:"id"`
 	Name      string `json:"name"`
 	Url       string `json:"url"`
 	Icon      string `json:"icon"`
 	Category  string `json:"category"`
 	SortIndex int    `json:"sortIndex"`
+	Board     string `json:"board,omitempty"` // 所属的导航板，只出现在合并视图和请求中，不保存
+}
+
+// LinkRequest 新增/修改链接的请求体，BaseLastModified 用于并发校验
+type LinkRequest struct {
+	Link
+	BaseLastModified int64 `json:"baseLastModified,omitempty"`
 }
 
 type Navigation struct {
@@ -65,6 +87,9 @@ type NavigationLastModified struct {
 type Config struct {
 	EnableNoAuth     bool `json:"enableNoAuth"`
 	EnableNoAuthView bool `json:"enableNoAuthView"`
+	EnableOIDC       bool `json:"enableOIDC"`
+	SessionCookie    bool `json:"sessionCookie"` // 登录令牌放在 HttpOnly cookie 中，前端不保存令牌
+	ForwardAuth      bool `json:"forwardAuth"`   // 由反向代理认证，前端不显示登录页
 }
 
 func loadConfig() {
@@ -72,13 +97,17 @@ func loadConfig() {
 	port := flag.String("port", "", "Port to listen on (e.g. 58080)")
 	user := flag.String("user", "", "Username for authentication")
 	password := flag.String("password", "", "Password for authentication")
+	passwordHash := flag.String("password-hash", "", "bcrypt or argon2id hash of the password (see hash-password command)")
 	noAuth := flag.Bool("no-auth", false, "Enable no-auth mode")
 	noAuthView := flag.Bool("no-auth-view", false, "Enable no-auth-view mode")
+	storageDriver := flag.String("storage", "", "Storage driver: json (default) or sqlite")
+	restoreRevision := flag.Int64("restore-revision", 0, "Restore navigation to the given revision id and exit")
+	restorePage := flag.String("page", "", "Page slug for --restore-revision (default: the first page)")
 	flag.Parse()
 
 	// 确保 data 目录存在
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		fmt.Errorf("failed to create data directory: %v", err)
+		log.Printf("Failed to create data directory: %v", err)
 	}
 
 	configPath := filepath.Join(dataDir, configFileName)
@@ -118,6 +147,25 @@ func loadConfig() {
 		}
 	}
 
+	envPasswordHash = os.Getenv("NAV_PASSWORD_HASH")
+	if envPasswordHash == "" {
+		if *passwordHash != "" {
+			envPasswordHash = *passwordHash
+		} else if cfg != nil {
+			envPasswordHash = cfg.Section("").Key("NAV_PASSWORD_HASH").String()
+		}
+	}
+	if envPasswordHash != "" {
+		if err := validatePasswordHash(envPasswordHash); err != nil {
+			log.Printf("Invalid NAV_PASSWORD_HASH: %v", err)
+		}
+		if envPassword != "" {
+			log.Printf("Both NAV_PASSWORD and NAV_PASSWORD_HASH are set, NAV_PASSWORD is ignored")
+		}
+	} else if envPassword != "" {
+		log.Printf("NAV_PASSWORD is stored in plaintext, consider NAV_PASSWORD_HASH (see `%s hash-password`)", os.Args[0])
+	}
+
 	noAuthStr := os.Getenv("ENABLE_NO_AUTH")
 	if cfg != nil {
 		noAuthStr = cfg.Section("").Key("ENABLE_NO_AUTH").MustString("false")
@@ -138,81 +186,291 @@ func loadConfig() {
 		envEnableNoAuthView = noAuthViewStr == "true"
 	}
 
-	log.Printf("Config loaded: LISTEN_PORT=%s, NAV_USERNAME=%s, ENABLE_NO_AUTH=%v, ENABLE_NO_AUTH_VIEW=%v", envPort, envUsername, envEnableNoAuth, envEnableNoAuthView)
-}
+	envStorageDriver = os.Getenv("STORAGE_DRIVER")
+	if envStorageDriver == "" {
+		if *storageDriver != "" {
+			envStorageDriver = *storageDriver
+		} else if cfg != nil {
+			envStorageDriver = cfg.Section("").Key("STORAGE_DRIVER").MustString(storageDriverJSON)
+		} else {
+			envStorageDriver = storageDriverJSON
+		}
+	}
 
-func loadNavigation() (Navigation, error) {
-	// 确保 data 目录存在
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return Navigation{}, fmt.Errorf("failed to create data directory: %v", err)
+	envMaxRevisions = defaultRevisionCount
+	if v := os.Getenv("MAX_REVISIONS"); v != "" {
+		fmt.Sscanf(v, "%d", &envMaxRevisions)
+	} else if cfg != nil {
+		envMaxRevisions = cfg.Section("").Key("MAX_REVISIONS").MustInt(defaultRevisionCount)
 	}
 
-	navPath := filepath.Join(dataDir, navigationFileName)
-	data, err := os.ReadFile(navPath)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return Navigation{}, err
+	requireTOTPStr := os.Getenv("REQUIRE_TOTP")
+	if requireTOTPStr == "" && cfg != nil {
+		requireTOTPStr = cfg.Section("").Key("REQUIRE_TOTP").String()
+	}
+	envRequireTOTP = requireTOTPStr == "true"
+
+	envOIDCIssuer = configValue(cfg, "OIDC_ISSUER", "")
+	envOIDCClientID = configValue(cfg, "OIDC_CLIENT_ID", "")
+	envOIDCClientSecret = configValue(cfg, "OIDC_CLIENT_SECRET", "")
+	envOIDCRedirectURL = configValue(cfg, "OIDC_REDIRECT_URL", "")
+	envOIDCScopes = configValue(cfg, "OIDC_SCOPES", "openid profile email")
+	envOIDCUsernameClaim = configValue(cfg, "OIDC_USERNAME_CLAIM", "preferred_username")
+	envOIDCRoleClaim = configValue(cfg, "OIDC_ROLE_CLAIM", "groups")
+	envOIDCRoleMapping = parseRoleMapping("OIDC_ROLE_MAPPING", configValue(cfg, "OIDC_ROLE_MAPPING", ""))
+	envOIDCDefaultRole = configValue(cfg, "OIDC_DEFAULT_ROLE", roleViewer)
+	if _, ok := roleLevels[envOIDCDefaultRole]; !ok && envOIDCDefaultRole != roleNone {
+		log.Printf("Invalid OIDC_DEFAULT_ROLE %q, using %s", envOIDCDefaultRole, roleViewer)
+		envOIDCDefaultRole = roleViewer
+	}
+	if oidcEnabled() {
+		log.Printf("OIDC login enabled: issuer=%s client_id=%s", envOIDCIssuer, envOIDCClientID)
+	}
+
+	envSessionCookie = configValue(cfg, "SESSION_COOKIE", "false") == "true"
+	envSessionCookieSecure = configValue(cfg, "SESSION_COOKIE_SECURE", "true") == "true"
+
+	envCORSOrigins = parseCORSOrigins(configValue(cfg, "CORS_ALLOWED_ORIGINS", ""))
+	envCORSRoutes = splitList(configValue(cfg, "CORS_ROUTES", ""))
+	envCORSCredentials = configValue(cfg, "CORS_ALLOW_CREDENTIALS", "false") == "true"
+	if len(envCORSOrigins) > 0 {
+		log.Printf("CORS enabled: origins=%v routes=%v credentials=%v", envCORSOrigins, envCORSRoutes, envCORSCredentials)
+	}
+
+	fmt.Sscanf(configValue(cfg, "ICON_SIZE", fmt.Sprintf("%d", defaultIconSize)), "%d", &envIconSize)
+	if envIconSize < minIconSize || envIconSize > maxIconSize {
+		log.Printf("Invalid ICON_SIZE %d, using %d", envIconSize, defaultIconSize)
+		envIconSize = defaultIconSize
+	}
+	envIconFetchAllowedNetworks = parseCIDRs("ICON_FETCH_ALLOWED_NETWORKS", configValue(cfg, "ICON_FETCH_ALLOWED_NETWORKS", ""))
+
+	envSecurityHeaders = configValue(cfg, "SECURITY_HEADERS", "true") == "true"
+	envContentSecurityPolicy = configValue(cfg, "CONTENT_SECURITY_POLICY", "")
+	envFrameAncestors = configValue(cfg, "FRAME_ANCESTORS", "'none'")
+	envReferrerPolicy = configValue(cfg, "REFERRER_POLICY", "no-referrer")
+	envPermissionsPolicy = configValue(cfg, "PERMISSIONS_POLICY", defaultPermissionsPolicy)
+	fmt.Sscanf(configValue(cfg, "HSTS_MAX_AGE", "0"), "%d", &envHSTSMaxAge)
+	envHSTSIncludeSubdomains = configValue(cfg, "HSTS_INCLUDE_SUBDOMAINS", "false") == "true"
+
+	envForwardAuthHeader = configValue(cfg, "FORWARD_AUTH_HEADER", "")
+	envForwardAuthGroupsHeader = configValue(cfg, "FORWARD_AUTH_GROUPS_HEADER", "")
+	envForwardAuthProxies = parseCIDRs("FORWARD_AUTH_TRUSTED_PROXIES", configValue(cfg, "FORWARD_AUTH_TRUSTED_PROXIES", ""))
+	envForwardAuthRoleMapping = parseRoleMapping("FORWARD_AUTH_ROLE_MAPPING", configValue(cfg, "FORWARD_AUTH_ROLE_MAPPING", ""))
+	envForwardAuthDefaultRole = configValue(cfg, "FORWARD_AUTH_DEFAULT_ROLE", roleViewer)
+	if _, ok := roleLevels[envForwardAuthDefaultRole]; !ok && envForwardAuthDefaultRole != roleNone {
+		log.Printf("Invalid FORWARD_AUTH_DEFAULT_ROLE %q, using %s", envForwardAuthDefaultRole, roleViewer)
+		envForwardAuthDefaultRole = roleViewer
+	}
+	if envForwardAuthHeader != "" && len(envForwardAuthProxies) == 0 {
+		log.Printf("FORWARD_AUTH_HEADER is set but FORWARD_AUTH_TRUSTED_PROXIES is empty, forward auth disabled")
+	}
+	if forwardAuthEnabled() {
+		log.Printf("Forward auth enabled: header=%s trusted_proxies=%v", envForwardAuthHeader, envForwardAuthProxies)
+	}
+	// 未单独配置时沿用反向代理认证的代理地址
+	envTrustedProxies = parseCIDRs("TRUSTED_PROXIES", configValue(cfg, "TRUSTED_PROXIES", ""))
+	if len(envTrustedProxies) == 0 {
+		envTrustedProxies = envForwardAuthProxies
+	}
+
+	restoreRevisionID = *restoreRevision
+	restorePageSlug = *restorePage
+
+	backupInterval := os.Getenv("BACKUP_INTERVAL")
+	if backupInterval == "" && cfg != nil {
+		backupInterval = cfg.Section("").Key("BACKUP_INTERVAL").String()
+	}
+	if backupInterval != "" && backupInterval != "0" {
+		if d, err := time.ParseDuration(backupInterval); err != nil {
+			log.Printf("Invalid BACKUP_INTERVAL %q: %v", backupInterval, err)
+		} else {
+			envBackupInterval = d
 		}
-		return Navigation{}, nil
 	}
-	var nav Navigation
-	err = json.Unmarshal(data, &nav)
-	if err != nil {
-		return Navigation{}, err
+
+	envBackupDir = os.Getenv("BACKUP_DIR")
+	if envBackupDir == "" {
+		if cfg != nil {
+			envBackupDir = cfg.Section("").Key("BACKUP_DIR").MustString(filepath.Join(dataDir, "backups"))
+		} else {
+			envBackupDir = filepath.Join(dataDir, "backups")
+		}
+	}
+
+	envBackupKeep = defaultBackupKeep
+	if v := os.Getenv("BACKUP_KEEP"); v != "" {
+		fmt.Sscanf(v, "%d", &envBackupKeep)
+	} else if cfg != nil {
+		envBackupKeep = cfg.Section("").Key("BACKUP_KEEP").MustInt(defaultBackupKeep)
 	}
-	return nav, nil
+
+	log.Printf("Config loaded: LISTEN_PORT=%s, NAV_USERNAME=%s, ENABLE_NO_AUTH=%v, ENABLE_NO_AUTH_VIEW=%v, STORAGE_DRIVER=%s, MAX_REVISIONS=%d", envPort, envUsername, envEnableNoAuth, envEnableNoAuthView, envStorageDriver, envMaxRevisions)
 }
 
-func saveNavigation(nav Navigation) error {
-	// 确保 data 目录存在
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return fmt.Errorf("failed to create data directory: %v", err)
+// configValue 读取配置项，环境变量优先于配置文件
+func configValue(cfg *ini.File, key string, defaultValue string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	if cfg != nil {
+		return cfg.Section("").Key(key).MustString(defaultValue)
 	}
+	return defaultValue
+}
 
-	currentTime := time.Now()
-	lastModified := currentTime.UnixNano() / int64(time.Millisecond)
-	nav.LastModified = lastModified
+// navigationETag 用 lastModified 作为导航数据的 ETag
+func navigationETag(nav *Navigation) string {
+	return versionETag(nav.LastModified)
+}
 
-	data, err := json.MarshalIndent(nav, "", "  ")
-	if err != nil {
-		return err
+func versionETag(version int64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// etagMatches 判断 If-Match 头中是否包含指定 ETag
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
 	}
+	return false
+}
+
+// requestError 表示需要以指定状态码返回给客户端的错误
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+func newRequestError(status int, format string, args ...interface{}) error {
+	return &requestError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// versionConflictError 表示客户端修改所基于的版本已过期
+type versionConflictError struct {
+	status int
+}
 
-	navPath := filepath.Join(dataDir, navigationFileName)
-	return os.WriteFile(navPath, data, 0644)
+func (e *versionConflictError) Error() string {
+	return "navigation has been modified"
 }
 
-// Token结构体用于存储token及其过期时间
+// checkNavigationVersion 校验客户端修改所基于的版本，
+// 优先使用 If-Match 头，其次是 baseLastModified 字段或查询参数，都没有时不校验。
+func checkNavigationVersion(r *http.Request, version int64, baseLastModified int64) error {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagMatches(ifMatch, versionETag(version)) {
+			return &versionConflictError{status: http.StatusPreconditionFailed}
+		}
+		return nil
+	}
+
+	if baseLastModified == 0 {
+		if v := r.URL.Query().Get("baseLastModified"); v != "" {
+			if _, err := fmt.Sscanf(v, "%d", &baseLastModified); err != nil {
+				return newRequestError(http.StatusBadRequest, "Invalid baseLastModified")
+			}
+		}
+	}
+	if baseLastModified != 0 && baseLastModified != version {
+		return &versionConflictError{status: http.StatusConflict}
+	}
+	return nil
+}
+
+// writeNavigationError 根据 NavigationStore.Update 返回的错误输出响应，
+// 版本冲突时返回 412/409 和当前的导航数据，由前端合并或提示。
+func writeNavigationError(w http.ResponseWriter, nav *Navigation, err error) {
+	var conflictErr *versionConflictError
+	switch {
+	case errors.As(err, &conflictErr):
+		data, err := json.MarshalIndent(nav, "", "  ")
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", navigationETag(nav))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(conflictErr.status)
+		w.Write(data)
+	default:
+		writeError(w, err)
+	}
+}
+
+// writeError 按 requestError 的状态码输出错误，其他错误返回 500
+func writeError(w http.ResponseWriter, err error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		http.Error(w, reqErr.message, reqErr.status)
+		return
+	}
+	log.Printf("Request failed: %v", err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+// Token结构体用于存储token及其过期时间。存储中只保存 token 的 SHA-256（即 tokens 的键），
+// Value 只出现在旧版本保存的数据中，加载时迁移
 type Token struct {
-	Value    string
-	ExpireAt time.Time
+	Value      string `json:",omitempty"`
+	ExpireAt   time.Time
+	Username   string // token 所属的用户，无用户密码模式下为空
+	ID         string // 会话 id，用于查看和注销会话，不暴露 token 本身
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	IP         string // 登录时的客户端 IP
+	UserAgent  string // 登录时的 User-Agent
 }
 
 // TokenStore结构体用于管理token存储
+// 续期（ExpireAt、LastUsedAt）只修改内存，由 flushLoop 定期写回；新增、注销 token 时立即保存。
 type TokenStore struct {
-	tokens   map[string]Token
-	mu       sync.Mutex
-	filePath string
+	tokens  map[string]Token // 按 token 的 SHA-256
+	mu      sync.RWMutex
+	storage Storage
+	dirty   bool // 有未写回存储的续期
 }
 
-func NewTokenStore() *TokenStore {
-	// 确保data目录存在
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		log.Printf("Failed to create data directory: %v", err)
-	}
-
+func NewTokenStore(storage Storage) *TokenStore {
 	ts := &TokenStore{
-		tokens:   make(map[string]Token),
-		filePath: filepath.Join(dataDir, tokenFileName),
+		tokens:  make(map[string]Token),
+		storage: storage,
 	}
 
-	// 从文件加载现有token
+	// 从存储加载现有token
 	ts.loadTokens()
+	go ts.flushLoop(tokenFlushPeriod)
 
 	return ts
 }
 
-// 保存tokens到文件
+// flushLoop 定期把续期写回存储
+func (ts *TokenStore) flushLoop(period time.Duration) {
+	for range time.Tick(period) {
+		ts.Flush()
+	}
+}
+
+// Flush 有未保存的续期时写回存储
+func (ts *TokenStore) Flush() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if !ts.dirty {
+		return
+	}
+	if err := ts.saveTokens(); err != nil {
+		log.Printf("Error saving tokens: %v", err)
+	}
+}
+
+// 保存tokens到存储
 func (ts *TokenStore) saveTokens() error {
 	// 清理过期的token
 	now := time.Now()
@@ -228,24 +486,25 @@ func (ts *TokenStore) saveTokens() error {
 		return fmt.Errorf("error marshaling tokens: %v", err)
 	}
 
-	// 写入文件
-	err = os.WriteFile(ts.filePath, data, 0644)
+	// 写入存储
+	err = ts.storage.Save(storageTokens, data)
 	if err != nil {
-		return fmt.Errorf("error writing tokens file: %v", err)
+		return fmt.Errorf("error writing tokens: %v", err)
 	}
 
+	ts.dirty = false
 	return nil
 }
 
-// 从文件加载tokens
+// 从存储加载tokens
 func (ts *TokenStore) loadTokens() {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 
-	data, err := os.ReadFile(ts.filePath)
+	data, err := ts.storage.Load(storageTokens)
 	if err != nil {
 		if !os.IsNotExist(err) {
-			log.Printf("Error reading tokens file: %v", err)
+			log.Printf("Error reading tokens: %v", err)
 		}
 		return
 	}
@@ -258,32 +517,71 @@ func (ts *TokenStore) loadTokens() {
 
 	// 清理已过期的token
 	now := time.Now()
+	changed := false
 	for k, v := range ts.tokens {
 		if now.After(v.ExpireAt) {
 			delete(ts.tokens, k)
+			continue
+		}
+		// 旧版本明文保存 token，改为按哈希保存
+		if v.Value != "" {
+			delete(ts.tokens, k)
+			k = hashToken(v.Value)
+			v.Value = ""
+			changed = true
+		}
+		// 多用户之前的token没有记录用户，属于配置文件中的管理员
+		if v.Username == "" && !envEnableNoAuth {
+			v.Username = envUsername
+		}
+		// 会话管理之前的token没有 id，分配后需要保存，否则每次重启 id 都会变化
+		if v.ID == "" {
+			v.ID = generateID()
+			changed = true
+		}
+		ts.tokens[k] = v
+	}
+	if changed {
+		if err := ts.saveTokens(); err != nil {
+			log.Printf("Error saving tokens: %v", err)
+		} else {
+			log.Printf("Migrated tokens to the current format")
 		}
 	}
 }
 
-// AddToken添加一个新的token到存储中，如果超过数量则删除最早的token
-func (ts *TokenStore) AddToken(token string, duration time.Duration) {
+// AddToken添加一个新的token到存储中，如果该用户的token超过数量则删除最早的token
+func (ts *TokenStore) AddToken(token string, duration time.Duration, username string, ip string, userAgent string) {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 
-	if len(ts.tokens) >= defaulttokenCount {
-		oldestToken := ""
-		oldestTime := time.Now()
-		for k, v := range ts.tokens {
-			if v.ExpireAt.Before(oldestTime) {
-				oldestToken = k
-				oldestTime = v.ExpireAt
-			}
+	count := 0
+	oldestToken := ""
+	var oldestTime time.Time
+	for k, v := range ts.tokens {
+		if v.Username != username {
+			continue
+		}
+		count++
+		if oldestToken == "" || v.ExpireAt.Before(oldestTime) {
+			oldestToken = k
+			oldestTime = v.ExpireAt
 		}
+	}
+	if count >= defaulttokenCount {
 		delete(ts.tokens, oldestToken)
 	}
 
-	expireAt := time.Now().Add(duration)
-	ts.tokens[token] = Token{Value: token, ExpireAt: expireAt}
+	now := time.Now()
+	ts.tokens[hashToken(token)] = Token{
+		ExpireAt:   now.Add(duration),
+		Username:   username,
+		ID:         generateID(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		IP:         ip,
+		UserAgent:  userAgent,
+	}
 
 	// 保存到文件
 	if err := ts.saveTokens(); err != nil {
@@ -291,26 +589,49 @@ func (ts *TokenStore) AddToken(token string, duration time.Duration) {
 	}
 }
 
-// ValidateToken验证token是否有效
-func (ts *TokenStore) ValidateToken(token string) bool {
+// ValidateToken验证token是否有效，有效时返回token信息。
+// 大部分请求只需要读锁；距上次续期超过 tokenTouchPeriod 时才在内存中续期，过期的 token 在保存时清理。
+func (ts *TokenStore) ValidateToken(token string) (Token, bool) {
+	hash := hashToken(token)
+	now := time.Now()
+
+	ts.mu.RLock()
+	t, exists := ts.tokens[hash]
+	ts.mu.RUnlock()
+	if !exists || now.After(t.ExpireAt) {
+		return Token{}, false
+	}
+	if now.Sub(t.LastUsedAt) < tokenTouchPeriod {
+		return t, true
+	}
+
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 
-	t, exists := ts.tokens[token]
-	if !exists {
-		return false
+	// 释放读锁期间 token 可能已被注销
+	if t, exists = ts.tokens[hash]; !exists {
+		return Token{}, false
 	}
-	if time.Now().After(t.ExpireAt) {
-		delete(ts.tokens, token)
-		return false
+	t.LastUsedAt = now
+	t.ExpireAt = now.Add(defaultExpireTime)
+	ts.tokens[hash] = t
+	ts.dirty = true
+	return t, true
+}
+
+// RevokeUser 使某个用户的所有token失效
+func (ts *TokenStore) RevokeUser(username string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for k, v := range ts.tokens {
+		if v.Username == username {
+			delete(ts.tokens, k)
+		}
 	}
-	newExpireAt := time.Now().Add(defaultExpireTime)
-	ts.tokens[token] = Token{Value: token, ExpireAt: newExpireAt}
-	// 保存到文件
 	if err := ts.saveTokens(); err != nil {
 		log.Printf("Error saving tokens: %v", err)
 	}
-	return true
 }
 
 // 生成随机令牌
@@ -323,6 +644,22 @@ func generateToken() (string, error) {
 	return base64.StdEncoding.EncodeToString(b), nil
 }
 
+// hashToken 返回令牌的 SHA-256，存储中只保存哈希
+func hashToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateID 生成随机 id，用于链接、页面和访问令牌
+func generateID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand 不可用时退化为时间戳
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
 // updateCategories 更新导航的分类列表，保持原有顺序，删除不存在的分类，添加新的分类
 func updateCategories(nav *Navigation) {
 	// 创建当前链接中存在的分类集合
@@ -367,18 +704,42 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authCheck := false
+	username := ""
 	if envEnableNoAuth {
 		log.Println("No authentication required (ENABLE_NO_AUTH=true)")
-		authCheck = true
 	} else {
-		if user.Username == envUsername && user.Password == envPassword {
-			authCheck = true
+		ip := clientIP(r)
+		// 锁定期间直接拒绝，不再校验密码
+		if remaining := loginLimiter.Locked(ip, user.Username); remaining > 0 {
+			log.Printf("Login failed: ip=%s user=%q reason=locked retry_after=%d", ip, user.Username, retryAfterSeconds(remaining))
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfterSeconds(remaining)))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
 		}
-	}
-	if !authCheck {
-
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if !authenticate(user.Username, user.Password) {
+			lockout := loginLimiter.Fail(ip, user.Username)
+			// 固定格式方便 fail2ban 匹配: Login failed: ip=<HOST>
+			log.Printf("Login failed: ip=%s user=%q reason=bad_credentials lockout=%d", ip, user.Username, retryAfterSeconds(lockout))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// 启用了两步验证的用户还需要验证码，未提交时提示前端输入，不计入失败次数
+		if totpStore.Enabled(user.Username) {
+			if user.TOTPCode == "" {
+				w.Header().Set("X-TOTP-Required", "true")
+				http.Error(w, "TOTP code required", http.StatusUnauthorized)
+				return
+			}
+			if !totpStore.Verify(user.Username, user.TOTPCode) {
+				lockout := loginLimiter.Fail(ip, user.Username)
+				log.Printf("Login failed: ip=%s user=%q reason=bad_totp lockout=%d", ip, user.Username, retryAfterSeconds(lockout))
+				w.Header().Set("X-TOTP-Required", "true")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		loginLimiter.Succeed(ip, user.Username)
+		username = user.Username
 	}
 
 	// 生成新的令牌
@@ -387,19 +748,82 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	tokenStore.AddToken(token, defaultExpireTime)
-	w.Header().Set("Authorization", token)
-	w.WriteHeader(http.StatusOK)
+	tokenStore.AddToken(token, defaultExpireTime, username, clientIP(r), r.UserAgent())
+	issueSession(w, r, token)
+}
+
+// retryAfterSeconds 把锁定时长向上取整为秒
+func retryAfterSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// authenticateRequest 验证请求中的令牌（登录令牌或访问令牌），没有令牌时使用反向代理传递的用户，返回登录信息
+func authenticateRequest(r *http.Request) (AuthInfo, bool) {
+	token := r.Header.Get("Authorization")
+	fromCookie := false
+	if token == "" {
+		if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+			token, fromCookie = cookie.Value, true
+		}
+	}
+	if token == "" {
+		return authenticateForwarded(r)
+	}
+	if strings.HasPrefix(token, apiTokenPrefix) {
+		t, ok := apiTokenStore.Validate(token)
+		if !ok {
+			return AuthInfo{}, false
+		}
+		role, ok := userRole(t.Username)
+		if !ok {
+			return AuthInfo{}, false
+		}
+		return AuthInfo{Username: t.Username, Role: role, Token: token, Scopes: t.Scopes}, true
+	}
+	t, ok := tokenStore.ValidateToken(token)
+	if !ok {
+		return AuthInfo{}, false
+	}
+	role, ok := userRole(t.Username)
+	if !ok {
+		return AuthInfo{}, false
+	}
+	return AuthInfo{Username: t.Username, Role: role, Token: token, Cookie: fromCookie}, true
 }
 
 // 中间件函数验证令牌
 func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if !tokenStore.ValidateToken(token) {
+		info, ok := authenticateRequest(r)
+		if !ok {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if scope := requestScope(r); !info.hasScope(scope) {
+			http.Error(w, fmt.Sprintf("Token scope '%s' required", scope), http.StatusForbidden)
+			return
+		}
+		if info.Cookie && !validCSRF(r, info.Token) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		// 强制两步验证时，尚未启用的用户登录后只能先完成设置
+		if totpEnrollmentPending(info) && !totpExemptPath(r.URL.Path) {
+			w.Header().Set("X-TOTP-Enrollment-Required", "true")
+			http.Error(w, "Two-factor authentication enrollment required", http.StatusForbidden)
+			return
+		}
+		next(w, withAuth(r, info))
+	}
+}
+
+// optionalAuthMiddleware 令牌有效时带上登录信息，否则按匿名请求处理，用于无用户密码浏览模式
+func optionalAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := authenticateRequest(r); ok && info.hasScope(requestScope(r)) && !totpEnrollmentPending(info) &&
+			(!info.Cookie || validCSRF(r, info.Token)) {
+			r = withAuth(r, info)
+		}
 		next(w, r)
 	}
 }
@@ -408,6 +832,9 @@ func getConfigHandler(w http.ResponseWriter, r *http.Request) {
 	config := Config{
 		EnableNoAuth:     envEnableNoAuth,
 		EnableNoAuthView: envEnableNoAuthView,
+		EnableOIDC:       oidcEnabled(),
+		SessionCookie:    envSessionCookie,
+		ForwardAuth:      forwardAuthEnabled(),
 	}
 	data, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
@@ -419,11 +846,17 @@ func getConfigHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func getNavigationHandler(w http.ResponseWriter, r *http.Request) {
-	nav, err := loadNavigation()
+	nav, err := requestView(r).Load()
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	etag := navigationETag(&nav)
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	data, err := json.MarshalIndent(nav, "", "  ")
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -434,7 +867,7 @@ func getNavigationHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func getNavigationLastModifiedHandler(w http.ResponseWriter, r *http.Request) {
-	nav, err := loadNavigation()
+	nav, err := requestView(r).Load()
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -443,6 +876,7 @@ func getNavigationLastModifiedHandler(w http.ResponseWriter, r *http.Request) {
 	response := NavigationLastModified{
 		LastModified: nav.LastModified,
 	}
+	w.Header().Set("ETag", navigationETag(&nav))
 	data, err := json.MarshalIndent(response, "", "  ")
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -452,10 +886,43 @@ func getNavigationLastModifiedHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-func validateTokenHandler(w http.ResponseWriter, r *http.Request) {
+// writeJSON 以 JSON 格式输出响应
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"ok"}`))
+	w.Write(data)
+}
+
+func validateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	info, _ := requestAuth(r)
+	// 令牌在服务端按使用续期，cookie 也随之续期
+	if info.Cookie {
+		setSessionCookies(w, info.Token)
+	}
+	writeJSON(w, map[string]string{
+		"status":   "ok",
+		"username": info.Username,
+		"role":     info.Role,
+	})
+}
+
+// decodeLinkRequest 解析并校验新增/修改链接的请求体
+func decodeLinkRequest(r *http.Request) (LinkRequest, error) {
+	var req LinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, newRequestError(http.StatusBadRequest, "Bad Request")
+	}
+	if len(req.Url) == 0 {
+		return req, newRequestError(http.StatusBadRequest, "Url required")
+	}
+	if len(req.Category) == 0 {
+		return req, newRequestError(http.StatusBadRequest, "Category required")
+	}
+	return req, nil
 }
 
 func addLinkHandler(w http.ResponseWriter, r *http.Request) {
@@ -463,33 +930,32 @@ func addLinkHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	var newLink Link
-	err := json.NewDecoder(r.Body).Decode(&newLink)
+	req, err := decodeLinkRequest(r)
 	if err != nil {
-		http.Error(w, "Bad Request", http.StatusBadRequest)
-		return
-	}
-	if len(newLink.Url) == 0 {
-		http.Error(w, "Url required", http.StatusBadRequest)
-		return
-	}
-	if len(newLink.Category) == 0 {
-		http.Error(w, "Category required", http.StatusBadRequest)
+		writeNavigationError(w, nil, err)
 		return
 	}
-	nav, err := loadNavigation()
+	board := requestBoard(r, req.Board, boardShared)
+	newLink := req.Link
+	// id 由服务端分配，忽略客户端传入的值
+	newLink.ID = generateID()
+	newLink.Board = ""
+	nav, err := requestView(r).Update(board, req.BaseLastModified, Change{Author: requestAuthor(r), Action: "add"}, func(nav *Navigation) error {
+		nav.Links = append(nav.Links, newLink)
+		updateCategories(nav)
+		return nil
+	})
 	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeNavigationError(w, &nav, err)
 		return
 	}
-	nav.Links = append(nav.Links, newLink)
-	updateCategories(&nav)
-	err = saveNavigation(nav)
-	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	// 返回保存后的链接（图标已经过处理）
+	if i := findLinkIndex(&nav, newLink.ID); i >= 0 {
+		newLink = nav.Links[i]
 	}
-	w.WriteHeader(http.StatusOK)
+	newLink.Board = board
+	w.Header().Set("ETag", navigationETag(&nav))
+	writeJSON(w, newLink)
 }
 
 func updateLinkHandler(w http.ResponseWriter, r *http.Request) {
@@ -497,38 +963,37 @@ func updateLinkHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	var updatedLink Link
-	err := json.NewDecoder(r.Body).Decode(&updatedLink)
+	req, err := decodeLinkRequest(r)
 	if err != nil {
-		http.Error(w, "Bad Request", http.StatusBadRequest)
-		return
-	}
-	if len(updatedLink.Url) == 0 {
-		http.Error(w, "Url required", http.StatusBadRequest)
-		return
-	}
-	if len(updatedLink.Category) == 0 {
-		http.Error(w, "Category required", http.StatusBadRequest)
+		writeNavigationError(w, nil, err)
 		return
 	}
 	var index int
 	fmt.Sscanf(r.URL.Path, "/navigation/update/%d", &index)
-	nav, err := loadNavigation()
+	// 索引是合并视图中的位置
+	view := requestView(r)
+	current, err := view.linkAt(index)
 	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-	if index < 0 || index >= len(nav.Links) {
-		http.Error(w, "Index out of range", http.StatusBadRequest)
+		writeNavigationError(w, nil, err)
 		return
 	}
-	nav.Links[index] = updatedLink
-	updateCategories(&nav)
-	err = saveNavigation(nav)
+	updatedLink := req.Link
+	updatedLink.ID = current.ID
+	updatedLink.Board = ""
+	nav, err := view.Update(current.Board, req.BaseLastModified, Change{Author: requestAuthor(r), Action: "update"}, func(nav *Navigation) error {
+		i := findLinkIndex(nav, current.ID)
+		if i < 0 {
+			return newRequestError(http.StatusNotFound, "Link not found")
+		}
+		nav.Links[i] = updatedLink
+		updateCategories(nav)
+		return nil
+	})
 	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeNavigationError(w, &nav, err)
 		return
 	}
+	w.Header().Set("ETag", navigationETag(&nav))
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -539,20 +1004,95 @@ func deleteLinkHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	var index int
 	fmt.Sscanf(r.URL.Path, "/navigation/delete/%d", &index)
-	nav, err := loadNavigation()
+	// 索引是合并视图中的位置
+	view := requestView(r)
+	current, err := view.linkAt(index)
 	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeNavigationError(w, nil, err)
 		return
 	}
-	if index < 0 || index >= len(nav.Links) {
-		http.Error(w, "Index out of range", http.StatusBadRequest)
+	nav, err := view.Update(current.Board, 0, Change{Author: requestAuthor(r), Action: "delete"}, func(nav *Navigation) error {
+		i := findLinkIndex(nav, current.ID)
+		if i < 0 {
+			return newRequestError(http.StatusNotFound, "Link not found")
+		}
+		nav.Links = append(nav.Links[:i], nav.Links[i+1:]...)
+		updateCategories(nav)
+		return nil
+	})
+	if err != nil {
+		writeNavigationError(w, &nav, err)
 		return
 	}
-	nav.Links = append(nav.Links[:index], nav.Links[index+1:]...)
-	updateCategories(&nav)
-	err = saveNavigation(nav)
+	w.Header().Set("ETag", navigationETag(&nav))
+	w.WriteHeader(http.StatusOK)
+}
+
+// linkHandler 通过 id 操作单个链接: PUT 更新, DELETE 删除
+func linkHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/navigation/links/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Link id required", http.StatusBadRequest)
+		return
+	}
+
+	var req LinkRequest
+	switch r.Method {
+	case http.MethodPut:
+		var err error
+		if req, err = decodeLinkRequest(r); err != nil {
+			writeNavigationError(w, nil, err)
+			return
+		}
+	case http.MethodDelete:
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 没有指定导航板时，修改链接当前所在的导航板
+	view := requestView(r)
+	board := requestBoard(r, req.Board, "")
+	if board == "" {
+		var err error
+		if board, err = view.linkBoard(id); err != nil {
+			writeNavigationError(w, nil, err)
+			return
+		}
+	}
+
+	updatedLink := req.Link
+	updatedLink.ID = id
+	updatedLink.Board = ""
+	action := "update"
+	if r.Method == http.MethodDelete {
+		action = "delete"
+	}
+	nav, err := view.Update(board, req.BaseLastModified, Change{Author: requestAuthor(r), Action: action}, func(nav *Navigation) error {
+		index := findLinkIndex(nav, id)
+		if index < 0 {
+			return newRequestError(http.StatusNotFound, "Link not found")
+		}
+		if r.Method == http.MethodPut {
+			nav.Links[index] = updatedLink
+		} else {
+			nav.Links = append(nav.Links[:index], nav.Links[index+1:]...)
+		}
+		updateCategories(nav)
+		return nil
+	})
 	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeNavigationError(w, &nav, err)
+		return
+	}
+
+	w.Header().Set("ETag", navigationETag(&nav))
+	if r.Method == http.MethodPut {
+		if i := findLinkIndex(&nav, id); i >= 0 {
+			updatedLink = nav.Links[i]
+		}
+		updatedLink.Board = board
+		writeJSON(w, updatedLink)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -560,10 +1100,12 @@ func deleteLinkHandler(w http.ResponseWriter, r *http.Request) {
 
 type UpdateSortIndexRequest struct {
 	Updates []struct {
+		ID        string `json:"id,omitempty"`       // 链接 id，优先于 index
 		Index     int    `json:"index"`              // 链接在数组中的索引
 		SortIndex int    `json:"sortIndex"`          // 新的排序索引值
 		Category  string `json:"category,omitempty"` // 可选的分类更新
 	} `json:"updates"`
+	BaseLastModified int64 `json:"baseLastModified,omitempty"` // 客户端所基于的版本
 }
 
 func updateSortIndicesHandler(w http.ResponseWriter, r *http.Request) {
@@ -578,41 +1120,79 @@ func updateSortIndicesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	nav, err := loadNavigation()
+	view := requestView(r)
+	current, err := view.Load()
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	// 批量更新 sortIndex 和 category
-	needUpdaeCategories := false
+	// 把索引换成 id，并按链接所在的导航板分组
+	type sortUpdate struct {
+		id        string
+		sortIndex int
+		category  string
+	}
+	updatesByBoard := make(map[string][]sortUpdate)
 	for _, update := range req.Updates {
-		if update.Index < 0 || update.Index >= len(nav.Links) {
-			http.Error(w, fmt.Sprintf("Invalid index: %d", update.Index), http.StatusBadRequest)
-			return
+		index := update.Index
+		if update.ID != "" {
+			index = findLinkIndex(&current, update.ID)
+			if index < 0 {
+				writeNavigationError(w, nil, newRequestError(http.StatusNotFound, "Link not found: %s", update.ID))
+				return
+			}
 		}
-		nav.Links[update.Index].SortIndex = update.SortIndex
-		if update.Category != "" {
-			nav.Links[update.Index].Category = update.Category
-			needUpdaeCategories = true
+		if index < 0 || index >= len(current.Links) {
+			writeNavigationError(w, nil, newRequestError(http.StatusBadRequest, "Invalid index: %d", update.Index))
+			return
 		}
-	}
+		link := current.Links[index]
+		updatesByBoard[link.Board] = append(updatesByBoard[link.Board], sortUpdate{
+			id:        link.ID,
+			sortIndex: update.SortIndex,
+			category:  update.Category,
+		})
+	}
+
+	fns := make(map[string]func(nav *Navigation) error)
+	for board, updates := range updatesByBoard {
+		updates := updates
+		fns[board] = func(nav *Navigation) error {
+			// 批量更新 sortIndex 和 category
+			needUpdaeCategories := false
+			for _, update := range updates {
+				index := findLinkIndex(nav, update.id)
+				if index < 0 {
+					return newRequestError(http.StatusNotFound, "Link not found: %s", update.id)
+				}
+				nav.Links[index].SortIndex = update.sortIndex
+				if update.category != "" {
+					nav.Links[index].Category = update.category
+					needUpdaeCategories = true
+				}
+			}
 
-	// 更新分类列表
-	if needUpdaeCategories {
-		updateCategories(&nav)
+			// 更新分类列表
+			if needUpdaeCategories {
+				updateCategories(nav)
+			}
+			return nil
+		}
 	}
-
-	if err := saveNavigation(nav); err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	nav, err := view.UpdateEach(req.BaseLastModified, Change{Author: requestAuthor(r), Action: "sort"}, fns)
+	if err != nil {
+		writeNavigationError(w, &nav, err)
 		return
 	}
 
+	w.Header().Set("ETag", navigationETag(&nav))
 	w.WriteHeader(http.StatusOK)
 }
 
 type UpdateCategorysRequest struct {
-	Categories []string `json:"categories"`
+	Categories       []string `json:"categories"`
+	BaseLastModified int64    `json:"baseLastModified,omitempty"` // 客户端所基于的版本
 }
 
 func updateCategoriesHandler(w http.ResponseWriter, r *http.Request) {
@@ -628,42 +1208,43 @@ func updateCategoriesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 加载当前导航数据
-	nav, err := loadNavigation()
+	view := requestView(r)
+	current, err := view.Load()
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-
-	// 获取当前所有实际使用的分类
-	currentCategories := make(map[string]struct{})
-	for _, link := range nav.Links {
-		if link.Category != "" {
-			currentCategories[link.Category] = struct{}{}
-		}
+	if err := checkCategoriesInUse(current.Links, req.Categories); err != nil {
+		writeNavigationError(w, nil, err)
+		return
 	}
 
-	// 验证新的分类列表包含所有正在使用的分类
-	for category := range currentCategories {
-		found := false
-		for _, newCategory := range req.Categories {
-			if category == newCategory {
-				found = true
-				break
-			}
+	// 每个导航板只保存自己用到的分类，顺序与请求一致，分类没有变化的导航板不修改
+	fns := make(map[string]func(nav *Navigation) error)
+	for _, board := range []string{boardShared, boardPersonal} {
+		store, _, err := view.stores(board)
+		if err != nil {
+			continue
 		}
-		if !found {
-			http.Error(w, fmt.Sprintf("Cannot remove category '%s' that is still in use", category), http.StatusBadRequest)
+		nav, err := store.Load()
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+		if reflect.DeepEqual(boardCategories(nav.Links, req.Categories), nav.Categories) {
+			continue
+		}
+		fns[board] = func(nav *Navigation) error {
+			if err := checkCategoriesInUse(nav.Links, req.Categories); err != nil {
+				return err
+			}
+			nav.Categories = boardCategories(nav.Links, req.Categories)
+			return nil
+		}
 	}
-
-	// 更新分类列表
-	nav.Categories = req.Categories
-
-	// 保存更新后的导航数据
-	if err := saveNavigation(nav); err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	nav, err := view.UpdateEach(req.BaseLastModified, Change{Author: requestAuthor(r), Action: "categories"}, fns)
+	if err != nil {
+		writeNavigationError(w, &nav, err)
 		return
 	}
 
@@ -673,10 +1254,47 @@ func updateCategoriesHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("ETag", navigationETag(&nav))
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(data)
 }
 
+// checkCategoriesInUse 验证新的分类列表包含所有正在使用的分类
+func checkCategoriesInUse(links []Link, categories []string) error {
+	for _, link := range links {
+		if link.Category == "" {
+			continue
+		}
+		found := false
+		for _, category := range categories {
+			if link.Category == category {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return newRequestError(http.StatusBadRequest, "Cannot remove category '%s' that is still in use", link.Category)
+		}
+	}
+	return nil
+}
+
+// boardCategories 按 categories 的顺序返回 links 用到的分类
+func boardCategories(links []Link, categories []string) []string {
+	used := make(map[string]bool)
+	for _, link := range links {
+		used[link.Category] = true
+	}
+	result := make([]string, 0, len(categories))
+	for _, category := range categories {
+		if used[category] {
+			result = append(result, category)
+			delete(used, category)
+		}
+	}
+	return result
+}
+
 // 记录访问日志的中间件
 func logAccessMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -700,149 +1318,158 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.ResponseWriter.WriteHeader(code)
 }
 
-// 调试接口，输出所有的 token
-func debugTokensHandler(w http.ResponseWriter, r *http.Request) {
-	tokenStore.mu.Lock()
-	defer tokenStore.mu.Unlock()
-
-	var tokens []string
-	for _, token := range tokenStore.tokens {
-		tokens = append(tokens, token.Value)
-	}
-
-	log.Printf("Current tokens: %v", tokens)
-	w.WriteHeader(http.StatusOK)
-}
-
 func getIconHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	token := r.Header.Get("Authorization")
-	if !tokenStore.ValidateToken(token) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
 	url := r.URL.Query().Get("url")
 	if url == "" {
 		http.Error(w, "Missing URL parameter", http.StatusBadRequest)
 		return
 	}
 
-	b := besticon.New(besticon.WithLogger(besticon.NewDefaultLogger(io.Discard)))
-	finder := b.NewIconFinder()
-	icons, err := finder.FetchIcons(url)
-	if err != nil {
-		log.Printf("Fetched icon from: %s err:%s", url, err)
-		http.Error(w, "Failed to fetch icons", http.StatusBadRequest)
+	if ref, ok := iconCache.Lookup(url); ok {
+		writeJSON(w, map[string]string{"icon": ref})
 		return
 	}
 
-	if len(icons) == 0 {
-		log.Printf("No icons from: %s", url)
-		http.Error(w, "No icons", http.StatusBadRequest)
+	icons, err := fetchIcons(url)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	best := icons[0]
-	log.Printf("Fetched icon ok %s:  %s", url, best.URL)
-
-	// 返回base64编码的图标数据
-	iconResponse := getIconResponse(best)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(iconResponse)
-}
-
-func getIconResponse(best besticon.Icon) map[string]string {
-	// 1. 建立格式与 MIME 类型的映射表（覆盖常见图标格式）
-	formatToContentType := map[string]string{
-		"jpg":  "image/jpeg", // jpg 对应标准 MIME 类型 image/jpeg
-		"jpeg": "image/jpeg", // 兼容 jpeg 格式标识
-		"png":  "image/png",
-		"svg":  "image/svg+xml", // svg 需指定为 image/svg+xml
-		"gif":  "image/gif",     // 可选：扩展支持 gif 格式
-		"ico":  "image/x-icon",  // 可选：扩展支持 ico 图标格式
-	}
-
-	// 2. 根据 best.Format 查找对应的 contentType，处理未知格式
-	contentType, ok := formatToContentType[best.Format]
-	if !ok {
-		// 未知格式时，默认使用二进制流类型（或根据业务需求调整，如报错）
-		log.Printf("Unknow image type %s", best.Format)
-		contentType = "application/octet-stream"
+	// 按优先级依次尝试，图标处理后存入缓存，返回 /icons/<hash> 引用
+	var ref string
+	for _, icon := range icons {
+		if ref, err = iconCache.Put(icon.Data); err == nil {
+			log.Printf("Fetched icon ok %s:  %s", url, icon.URL)
+			break
+		}
+		log.Printf("Skipped icon %s: %v", icon.URL, err)
 	}
-
-	base64Data := base64.StdEncoding.EncodeToString(best.ImageData)
-	iconResponse := map[string]string{
-		"iconData": fmt.Sprintf("data:%s;base64,%s", contentType, base64Data),
+	if err != nil {
+		http.Error(w, "No usable icons", http.StatusUnprocessableEntity)
+		return
 	}
-	return iconResponse
-}
-
-// CORS 中间件
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 设置允许的来源
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-
-		// 允许的方法
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-
-		// 允许的请求头
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, Authorization")
-
-		// 允许暴露的响应头
-		w.Header().Set("Access-Control-Expose-Headers", "Authorization")
-
-		// 允许凭证
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-		// 缓存预检请求结果
-		w.Header().Set("Access-Control-Max-Age", "86400")
-
-		// 处理预检请求
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
+	iconCache.Remember(url, ref)
+	writeJSON(w, map[string]string{"icon": ref})
 }
 
 func main() {
 	// Add a simple usage message
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [options] <command> [args]\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nCommands:\n")
+		fmt.Fprintf(os.Stderr, "  restore <backup.tar.gz>   Validate a backup and swap it in as the data directory\n")
+		fmt.Fprintf(os.Stderr, "  hash-password [--algo=bcrypt|argon2id]\n")
+		fmt.Fprintf(os.Stderr, "                            Read a password from stdin and print a hash for NAV_PASSWORD_HASH\n")
 		fmt.Fprintf(os.Stderr, "\nExample:\n")
 		fmt.Fprintf(os.Stderr, "  %s --port=58080 --user=admin --password=123456\n", os.Args[0])
 	}
 
 	loadConfig()
-	tokenStore = NewTokenStore()
+
+	// 子命令
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "restore":
+			runRestoreCommand(args[1:])
+		case "hash-password":
+			runHashPasswordCommand(args[1:])
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", args[0])
+			flag.Usage()
+			os.Exit(2)
+		}
+		return
+	}
+
+	storage, err := openStorage(envStorageDriver)
+	if err != nil {
+		log.Fatal("Failed to open storage: ", err)
+	}
+	defer storage.Close()
+	dataStorage = storage
+	settingsStore = NewSettingsStore(dataStorage)
+	tokenStore = NewTokenStore(dataStorage)
+	userStore = NewUserStore(dataStorage)
+	apiTokenStore = NewAPITokenStore(dataStorage)
+	totpStore = NewTOTPStore(dataStorage)
+	pageStore = NewPageStore(dataStorage)
+	iconCache = NewIconCache(filepath.Join(dataDir, iconDirName))
+
+	if restoreRevisionID > 0 {
+		page := pageStore.First()
+		if restorePageSlug != "" {
+			var ok bool
+			if page, ok = pageStore.Get(restorePageSlug); !ok {
+				log.Fatalf("Page not found: %s", restorePageSlug)
+			}
+		}
+		data := pageStore.Open(page)
+		restore, err := data.revisions.Restorer(restoreRevisionID)
+		if err != nil {
+			log.Fatalf("Failed to restore revision %d: %v", restoreRevisionID, err)
+		}
+		nav, err := data.nav.Update(Change{Author: "cli", Action: "restore"}, restore)
+		if err != nil {
+			log.Fatalf("Failed to restore revision %d: %v", restoreRevisionID, err)
+		}
+		log.Printf("Restored revision %d: %d links, lastModified=%d", restoreRevisionID, len(nav.Links), nav.LastModified)
+		return
+	}
+
+	// 启动时读取各页面的导航数据，迁移旧格式（个人导航板在第一次访问时迁移）
+	for _, page := range pageStore.List() {
+		if _, err := pageStore.Open(page).nav.Load(); err != nil {
+			log.Printf("Failed to load page %s: %v", page.Slug, err)
+		}
+	}
+
+	startBackupScheduler(envBackupInterval, envBackupDir, envBackupKeep)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/login", loginHandler)
+	mux.HandleFunc("/oidc/login", oidcLoginHandler)
+	mux.HandleFunc("/oidc/callback", oidcCallbackHandler)
+	mux.HandleFunc("/oidc/token", oidcTokenHandler)
 	if envEnableNoAuthView {
-		mux.HandleFunc("/navigation", getNavigationHandler)
-		mux.HandleFunc("/navigation/last-modified", getNavigationLastModifiedHandler)
+		mux.HandleFunc("/navigation", optionalAuthMiddleware(getNavigationHandler))
+		mux.HandleFunc("/navigation/last-modified", optionalAuthMiddleware(getNavigationLastModifiedHandler))
+		mux.HandleFunc("/pages", optionalAuthMiddleware(pagesHandler))
+		mux.HandleFunc("/pages/", optionalAuthMiddleware(pagesHandler))
 	} else {
 		mux.HandleFunc("/navigation", authMiddleware(getNavigationHandler))
 		mux.HandleFunc("/navigation/last-modified", authMiddleware(getNavigationLastModifiedHandler))
-	}
-	mux.HandleFunc("/navigation/add", authMiddleware(addLinkHandler))
-	mux.HandleFunc("/navigation/update/", authMiddleware(updateLinkHandler))
-	mux.HandleFunc("/navigation/delete/", authMiddleware(deleteLinkHandler))
-	mux.HandleFunc("/navigation/sort", authMiddleware(updateSortIndicesHandler))
-	mux.HandleFunc("/navigation/categories", authMiddleware(updateCategoriesHandler))
-	mux.HandleFunc("/debug/tokens", debugTokensHandler)
-	mux.HandleFunc("/get-icon", authMiddleware(getIconHandler))
+		mux.HandleFunc("/pages", authMiddleware(pagesHandler))
+		mux.HandleFunc("/pages/", authMiddleware(pagesHandler))
+	}
+	mux.HandleFunc("/navigation/add", roleMiddleware(roleEditor, addLinkHandler))
+	mux.HandleFunc("/navigation/update/", roleMiddleware(roleEditor, updateLinkHandler))
+	mux.HandleFunc("/navigation/delete/", roleMiddleware(roleEditor, deleteLinkHandler))
+	mux.HandleFunc("/navigation/links/", roleMiddleware(roleEditor, linkHandler))
+	mux.HandleFunc("/navigation/sort", roleMiddleware(roleEditor, updateSortIndicesHandler))
+	mux.HandleFunc("/navigation/categories", roleMiddleware(roleEditor, updateCategoriesHandler))
+	mux.HandleFunc("/navigation/revisions", authMiddleware(revisionsHandler))
+	mux.HandleFunc("/navigation/revisions/", authMiddleware(revisionsHandler))
+	mux.HandleFunc("/get-icon", roleMiddleware(roleEditor, getIconHandler))
+	mux.HandleFunc("/icons/", iconHandler)
+	mux.HandleFunc("/admin/users", roleMiddleware(roleAdmin, usersHandler))
+	mux.HandleFunc("/admin/users/", roleMiddleware(roleAdmin, usersHandler))
 	mux.HandleFunc("/config", getConfigHandler)
 	mux.HandleFunc("/validate", authMiddleware(validateTokenHandler))
+	mux.HandleFunc("/tokens", authMiddleware(apiTokensHandler))
+	mux.HandleFunc("/tokens/", authMiddleware(apiTokensHandler))
+	mux.HandleFunc("/logout", authMiddleware(logoutHandler))
+	mux.HandleFunc("/sessions", authMiddleware(sessionsHandler))
+	mux.HandleFunc("/sessions/", authMiddleware(sessionsHandler))
+	mux.HandleFunc("/totp", authMiddleware(totpHandler))
+	mux.HandleFunc("/totp/", authMiddleware(totpHandler))
 
 	// 静态文件
 	staticFiles, err := fs.Sub(embeddedFiles, "public")
@@ -851,9 +1478,22 @@ func main() {
 	}
 	fileServer := http.FileServer(http.FS(staticFiles))
 	mux.Handle("/", fileServer)
-
-	// 使用 CORS 中间件和日志中间件
-	handler := corsMiddleware(logAccessMiddleware(mux))
+	// /p/<slug>/ 下为各个页面，接口和前端页面都按页面区分
+	mux.HandleFunc("/p/", pagePrefixHandler(mux))
+
+	// 使用安全响应头、CORS 和日志中间件
+	initSecurityHeaders(staticFiles)
+	handler := securityHeadersMiddleware(corsMiddleware(logAccessMiddleware(mux)))
+
+	// 退出前写回 token 的续期
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		tokenStore.Flush()
+		storage.Close()
+		os.Exit(0)
+	}()
 
 	log.Printf("Server is running on http://localhost:%s\n", envPort)
 	err = http.ListenAndServe(":"+envPort, handler)
@@ -0,0 +1,637 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcProviderName   = "oidc"
+	oidcStateCookie    = "tiny_nav_oidc_state"
+	oidcStateTTL       = 10 * time.Minute // 从跳转到身份提供方到回调的最长时间
+	oidcHandoffTTL     = time.Minute      // 回调后前端换取令牌的最长时间
+	oidcKeysMinRefresh = time.Minute      // 遇到未知 kid 时重新获取 JWKS 的最小间隔
+	oidcClockSkew      = time.Minute
+	oidcHTTPTimeout    = 10 * time.Second
+)
+
+// OIDC 配置，OIDC_ISSUER 和 OIDC_CLIENT_ID 都设置时启用
+var (
+	envOIDCIssuer        string
+	envOIDCClientID      string
+	envOIDCClientSecret  string
+	envOIDCRedirectURL   string            // 回调地址，为空时根据请求推断为 <scheme>://<host>/oidc/callback
+	envOIDCScopes        string            // 空格分隔
+	envOIDCUsernameClaim string            // 作为用户名的 claim
+	envOIDCRoleClaim     string            // 用于映射角色的 claim，字符串或字符串数组
+	envOIDCRoleMapping   map[string]string // claim 值 -> 角色
+	envOIDCDefaultRole   string            // 没有匹配的映射时的角色
+)
+
+func oidcEnabled() bool {
+	return envOIDCIssuer != "" && envOIDCClientID != ""
+}
+
+// parseRoleMapping 解析 "group1=admin,group2=editor" 形式的角色映射，key 为配置项名称
+func parseRoleMapping(key string, s string) map[string]string {
+	mapping := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		value, role, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			continue
+		}
+		value, role = strings.TrimSpace(value), strings.TrimSpace(role)
+		if _, valid := roleLevels[role]; !valid {
+			log.Printf("Invalid role %q in %s, ignored", role, key)
+			continue
+		}
+		mapping[value] = role
+	}
+	return mapping
+}
+
+var oidc = &oidcProvider{
+	client:   &http.Client{Timeout: oidcHTTPTimeout},
+	pending:  make(map[string]oidcPending),
+	handoffs: make(map[string]oidcHandoff),
+}
+
+// oidcProvider 身份提供方的元数据、签名公钥，以及进行中的登录
+type oidcProvider struct {
+	client *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]crypto.PublicKey // 按 kid
+	keysFetchedAt time.Time
+	pending       map[string]oidcPending // 按 state
+	handoffs      map[string]oidcHandoff // 按一次性换取码
+}
+
+// oidcDiscovery /.well-known/openid-configuration 中用到的字段
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcPending 已跳转到身份提供方、等待回调的登录
+type oidcPending struct {
+	verifier    string // PKCE code_verifier
+	nonce       string
+	redirectURL string
+	createdAt   time.Time
+}
+
+// oidcHandoff 回调成功后交给前端换取令牌的一次性码，避免令牌出现在 URL 中
+type oidcHandoff struct {
+	token     string
+	createdAt time.Time
+}
+
+func (p *oidcProvider) getJSON(u string, v interface{}) error {
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// Discover 返回身份提供方的元数据，第一次调用时获取并缓存。网络请求不持有 p.mu，
+// 避免身份提供方响应慢时阻塞其他登录
+func (p *oidcProvider) Discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	discovery := p.discovery
+	p.mu.Unlock()
+	if discovery != nil {
+		return discovery, nil
+	}
+
+	issuer := strings.TrimSuffix(envOIDCIssuer, "/")
+	var d oidcDiscovery
+	if err := p.getJSON(issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %v", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", d.Issuer, envOIDCIssuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: missing endpoints")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery == nil { // 并发获取时保留先完成的结果
+		p.discovery = &d
+	}
+	return p.discovery, nil
+}
+
+// key 返回 kid 对应的签名公钥，本地没有时重新获取 JWKS（身份提供方可能轮换了密钥）。
+// 获取 JWKS 时不持有 p.mu，获取完成后再替换 p.keys
+func (p *oidcProvider) key(d *oidcDiscovery, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	if key, ok := p.keys[kid]; ok {
+		p.mu.Unlock()
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < oidcKeysMinRefresh {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	p.keysFetchedAt = time.Now() // 先记录时间，其他请求在获取期间不会重复获取
+	p.mu.Unlock()
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(d.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("Ignoring OIDC signing key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// jsonWebKey JWKS 中的公钥，支持 RSA 和 P-256 EC
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+// VerifyIDToken 校验 ID Token 的签名、issuer、audience、有效期和 nonce，返回其中的 claims
+func (p *oidcProvider) VerifyIDToken(d *oidcDiscovery, raw string, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed id token signature")
+	}
+	key, err := p.key(d, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return nil, errors.New("invalid id token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 ||
+			!ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			return nil, errors.New("invalid id token signature")
+		}
+	default:
+		return nil, errors.New("unsupported signing key")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(d.Issuer, "/") {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	if !claimContains(claims["aud"], envOIDCClientID) {
+		return nil, errors.New("id token is not issued for this client")
+	}
+	now := time.Now()
+	exp, _ := claims["exp"].(float64)
+	if now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("id token expired")
+	}
+	if n, _ := claims["nonce"].(string); !constantTimeEqual(n, nonce) {
+		return nil, errors.New("id token nonce mismatch")
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed id token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed id token")
+	}
+	return nil
+}
+
+// claimContains 判断字符串或字符串数组类型的 claim 是否包含 value
+func claimContains(claim interface{}, value string) bool {
+	return contains(claimValues(claim), value)
+}
+
+func claimValues(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// oidcRole 按 OIDC_ROLE_MAPPING 映射角色
+func oidcRole(claims map[string]interface{}) string {
+	return mapRole(claimValues(claims[envOIDCRoleClaim]), envOIDCRoleMapping, envOIDCDefaultRole)
+}
+
+// mapRole 按 mapping 映射角色，匹配多个时取最高的角色，都不匹配时为 defaultRole（none 表示不允许登录，返回空字符串）
+func mapRole(values []string, mapping map[string]string, defaultRole string) string {
+	role := ""
+	for _, value := range values {
+		if r, ok := mapping[value]; ok && roleLevels[r] > roleLevels[role] {
+			role = r
+		}
+	}
+	if role == "" && defaultRole != roleNone {
+		role = defaultRole
+	}
+	return role
+}
+
+// Begin 开始一次登录，返回 state 和身份提供方的授权地址
+func (p *oidcProvider) Begin(d *oidcDiscovery, redirectURL string) (string, string, error) {
+	state, err := randomURLString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomURLString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomURLString()
+	if err != nil {
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	p.mu.Lock()
+	now := time.Now()
+	for k, v := range p.pending {
+		if now.Sub(v.createdAt) > oidcStateTTL {
+			delete(p.pending, k)
+		}
+	}
+	p.pending[state] = oidcPending{verifier: verifier, nonce: nonce, redirectURL: redirectURL, createdAt: now}
+	p.mu.Unlock()
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", envOIDCClientID)
+	q.Set("redirect_uri", redirectURL)
+	q.Set("scope", envOIDCScopes)
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	authURL := d.AuthorizationEndpoint
+	if strings.Contains(authURL, "?") {
+		authURL += "&" + q.Encode()
+	} else {
+		authURL += "?" + q.Encode()
+	}
+	return state, authURL, nil
+}
+
+// takePending 取出并删除 state 对应的登录
+func (p *oidcProvider) takePending(state string) (oidcPending, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending, ok := p.pending[state]
+	delete(p.pending, state)
+	if !ok || time.Since(pending.createdAt) > oidcStateTTL {
+		return oidcPending{}, false
+	}
+	return pending, true
+}
+
+// Exchange 用授权码和 PKCE verifier 换取 ID Token
+func (p *oidcProvider) Exchange(d *oidcDiscovery, code string, pending oidcPending) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", pending.redirectURL)
+	form.Set("client_id", envOIDCClientID)
+	form.Set("code_verifier", pending.verifier)
+
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if envOIDCClientSecret != "" {
+		// client_secret_basic，用户名和密码需要先做 URL 编码（RFC 6749 2.3.1）
+		req.SetBasicAuth(url.QueryEscape(envOIDCClientID), url.QueryEscape(envOIDCClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("token endpoint: %s %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token endpoint returned no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// Handoff 保存登录令牌，返回给前端换取令牌的一次性码
+func (p *oidcProvider) Handoff(token string) (string, error) {
+	code, err := randomURLString()
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range p.handoffs {
+		if now.Sub(v.createdAt) > oidcHandoffTTL {
+			delete(p.handoffs, k)
+		}
+	}
+	p.handoffs[code] = oidcHandoff{token: token, createdAt: now}
+	return code, nil
+}
+
+// TakeHandoff 用一次性码换取登录令牌
+func (p *oidcProvider) TakeHandoff(code string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	handoff, ok := p.handoffs[code]
+	delete(p.handoffs, code)
+	if !ok || time.Since(handoff.createdAt) > oidcHandoffTTL {
+		return "", false
+	}
+	return handoff.token, true
+}
+
+func randomURLString() (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	return strings.NewReplacer("+", "-", "/", "_", "=", "").Replace(token), nil
+}
+
+// oidcRedirectURL 返回回调地址，未配置时根据请求推断
+func oidcRedirectURL(r *http.Request) string {
+	if envOIDCRedirectURL != "" {
+		return envOIDCRedirectURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/oidc/callback"
+}
+
+// oidcLoginHandler GET /oidc/login 跳转到身份提供方登录
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	d, err := oidc.Discover()
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	state, authURL, err := oidc.Begin(d, oidcRedirectURL(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// state 同时放在 cookie 中，回调时校验是同一个浏览器发起的登录
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/oidc/",
+		MaxAge:   int(oidcStateTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallbackHandler GET /oidc/callback 身份提供方回调：换取并校验 ID Token，
+// 创建登录令牌后跳回前端登录页，由前端用一次性码换取令牌
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	fail := func(reason string, err error) {
+		log.Printf("OIDC login failed: ip=%s reason=%s: %v", clientIP(r), reason, err)
+		http.Redirect(w, r, "/#/login?oidc_error="+url.QueryEscape(reason), http.StatusFound)
+	}
+
+	q := r.URL.Query()
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/oidc/", MaxAge: -1})
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || !constantTimeEqual(cookie.Value, q.Get("state")) {
+		fail("state", errors.New("state cookie mismatch"))
+		return
+	}
+	pending, ok := oidc.takePending(q.Get("state"))
+	if !ok {
+		fail("state", errors.New("unknown or expired state"))
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		fail("denied", fmt.Errorf("%s: %s", e, q.Get("error_description")))
+		return
+	}
+
+	d, err := oidc.Discover()
+	if err != nil {
+		fail("provider", err)
+		return
+	}
+	idToken, err := oidc.Exchange(d, q.Get("code"), pending)
+	if err != nil {
+		fail("exchange", err)
+		return
+	}
+	claims, err := oidc.VerifyIDToken(d, idToken, pending.nonce)
+	if err != nil {
+		fail("id_token", err)
+		return
+	}
+
+	username, _ := claims[envOIDCUsernameClaim].(string)
+	if username == "" {
+		fail("username", fmt.Errorf("claim %q missing", envOIDCUsernameClaim))
+		return
+	}
+	role := oidcRole(claims)
+	if role == "" {
+		fail("role", fmt.Errorf("no role mapped for user %q", username))
+		return
+	}
+	if _, err := userStore.PutExternal(username, role, oidcProviderName); err != nil {
+		fail("account", err)
+		return
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		fail("token", err)
+		return
+	}
+	tokenStore.AddToken(token, defaultExpireTime, username, clientIP(r), r.UserAgent())
+	code, err := oidc.Handoff(token)
+	if err != nil {
+		fail("token", err)
+		return
+	}
+	log.Printf("OIDC login: ip=%s user=%q role=%s", clientIP(r), username, role)
+	http.Redirect(w, r, "/#/login?oidc_code="+url.QueryEscape(code), http.StatusFound)
+}
+
+// oidcTokenHandler POST /oidc/token 前端用回调得到的一次性码换取登录令牌，令牌与 /login 一样返回
+func oidcTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	token, ok := oidc.TakeHandoff(req.Code)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	issueSession(w, r, token)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testOIDCClientID = "tiny-nav"
+
+// testIssuer 模拟身份提供方：discovery、JWKS 和 token 端点。
+// token 端点校验 PKCE code_verifier，返回 idToken 生成的 ID Token
+type testIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu         sync.Mutex
+	challenges map[string]string        // 授权码 -> code_challenge
+	idToken    func(code string) string // 授权码 -> ID Token
+	jwksHits   int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: key, challenges: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                issuer.URL,
+			AuthorizationEndpoint: issuer.URL + "/authorize",
+			TokenEndpoint:         issuer.URL + "/token",
+			JWKSURI:               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		issuer.jwksHits++
+		issuer.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{{
+			Kty: "RSA",
+			Kid: "test",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		code := r.PostFormValue("code")
+		issuer.mu.Lock()
+		challenge, ok := issuer.challenges[code]
+		delete(issuer.challenges, code)
+		issuer.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || r.PostFormValue("client_id") != testOIDCClientID ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.idToken(code)})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// authorize 模拟用户在身份提供方登录，记录 code_challenge 并返回授权码
+func (issuer *testIssuer) authorize(t *testing.T, authURL string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization request without PKCE: %s", authURL)
+	}
+	code := "code-" + q.Get("state")
+	issuer.mu.Lock()
+	issuer.challenges[code] = q.Get("code_challenge")
+	issuer.mu.Unlock()
+	return code
+}
+
+// sign 生成 RS256 签名的 JWT，alg 不是 RS256 时签名仍按 RS256 计算
+func (issuer *testIssuer) sign(t *testing.T, alg string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, issuer.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (issuer *testIssuer) claims(nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   issuer.URL,
+		"aud":   testOIDCClientID,
+		"sub":   "alice",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": nonce,
+	}
+}
+
+func setupTestOIDC(t *testing.T) (*testIssuer, *oidcProvider, *oidcDiscovery) {
+	t.Helper()
+	issuer := newTestIssuer(t)
+
+	oldIssuer, oldClientID, oldSecret, oldScopes := envOIDCIssuer, envOIDCClientID, envOIDCClientSecret, envOIDCScopes
+	envOIDCIssuer, envOIDCClientID, envOIDCClientSecret, envOIDCScopes = issuer.URL, testOIDCClientID, "", "openid"
+	t.Cleanup(func() {
+		envOIDCIssuer, envOIDCClientID, envOIDCClientSecret, envOIDCScopes = oldIssuer, oldClientID, oldSecret, oldScopes
+	})
+
+	p := &oidcProvider{
+		client:   issuer.Client(),
+		pending:  make(map[string]oidcPending),
+		handoffs: make(map[string]oidcHandoff),
+	}
+	d, err := p.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issuer, p, d
+}
+
+func TestOIDCLoginWithPKCE(t *testing.T) {
+	issuer, p, d := setupTestOIDC(t)
+
+	state, authURL, err := p.Begin(d, "http://nav.example/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := issuer.authorize(t, authURL)
+	pending, ok := p.takePending(state)
+	if !ok {
+		t.Fatal("pending login not found")
+	}
+	if _, ok := p.takePending(state); ok {
+		t.Fatal("state can be used twice")
+	}
+	issuer.idToken = func(string) string { return issuer.sign(t, "RS256", issuer.claims(pending.nonce)) }
+
+	// 错误的 code_verifier 不能换取令牌
+	wrong := pending
+	wrong.verifier = "wrong-verifier"
+	if _, err := p.Exchange(d, code, wrong); err == nil {
+		t.Fatal("exchange succeeded with a wrong code_verifier")
+	}
+
+	state, authURL, err = p.Begin(d, "http://nav.example/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code = issuer.authorize(t, authURL)
+	pending, _ = p.takePending(state)
+	idToken, err := p.Exchange(d, code, pending)
+	if err != nil {
+		t.Fatalf("exchange failed: %v", err)
+	}
+	claims, err := p.VerifyIDToken(d, idToken, pending.nonce)
+	if err != nil {
+		t.Fatalf("valid id token rejected: %v", err)
+	}
+	if claims["sub"] != "alice" {
+		t.Fatalf("unexpected claims: %v", claims)
+	}
+}
+
+func TestOIDCVerifyIDTokenRejects(t *testing.T) {
+	issuer, p, d := setupTestOIDC(t)
+	const nonce = "expected-nonce"
+
+	tests := []struct {
+		name   string
+		alg    string
+		modify func(claims map[string]interface{})
+		token  func() string
+	}{
+		{name: "alg none", token: func() string {
+			header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"test"}`))
+			payload, _ := json.Marshal(issuer.claims(nonce))
+			return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+		}},
+		{name: "alg HS256", alg: "HS256"},
+		{name: "bad signature", token: func() string {
+			token := issuer.sign(t, "RS256", issuer.claims(nonce))
+			payload, _ := json.Marshal(map[string]interface{}{"iss": issuer.URL, "aud": testOIDCClientID, "sub": "admin",
+				"exp": time.Now().Add(time.Hour).Unix(), "nonce": nonce})
+			parts := strings.Split(token, ".")
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+		}},
+		{name: "issuer", modify: func(c map[string]interface{}) { c["iss"] = "https://evil.example" }},
+		{name: "audience", modify: func(c map[string]interface{}) { c["aud"] = "other-client" }},
+		{name: "audience list", modify: func(c map[string]interface{}) { c["aud"] = []string{"other-client"} }},
+		{name: "expired", modify: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "missing exp", modify: func(c map[string]interface{}) { delete(c, "exp") }},
+		{name: "nonce", modify: func(c map[string]interface{}) { c["nonce"] = "other-nonce" }},
+		{name: "missing nonce", modify: func(c map[string]interface{}) { delete(c, "nonce") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := ""
+			if tt.token != nil {
+				token = tt.token()
+			} else {
+				claims := issuer.claims(nonce)
+				if tt.modify != nil {
+					tt.modify(claims)
+				}
+				alg := tt.alg
+				if alg == "" {
+					alg = "RS256"
+				}
+				token = issuer.sign(t, alg, claims)
+			}
+			if _, err := p.VerifyIDToken(d, token, nonce); err == nil {
+				t.Fatal("id token accepted")
+			}
+		})
+	}
+
+	// 同样的 claims 正确签名时可以通过，确认上面是因为被修改的部分而拒绝
+	if _, err := p.VerifyIDToken(d, issuer.sign(t, "RS256", issuer.claims(nonce)), nonce); err != nil {
+		t.Fatalf("valid id token rejected: %v", err)
+	}
+	// 公钥获取后缓存，所有校验只获取一次 JWKS
+	issuer.mu.Lock()
+	hits := issuer.jwksHits
+	issuer.mu.Unlock()
+	if hits != 1 {
+		t.Fatalf("jwks fetched %d times, want 1", hits)
+	}
+}
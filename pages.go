@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// defaultPageID 最早的（升级前唯一的）导航页，沿用原来的存储名称 navigation / revisions
+const defaultPageID = "default"
+
+var validPageSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// Page 一个导航页，通过 /p/<slug> 访问。数据按 ID 保存，修改 slug 不影响数据
+type Page struct {
+	ID    string `json:"id"`
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+}
+
+// pageDocument 返回页面共享导航板在存储中的名称
+func pageDocument(id string) string {
+	if id == defaultPageID {
+		return storageNavigation
+	}
+	return storagePagePrefix + id
+}
+
+// pageRevisionsDocument 返回页面历史版本在存储中的名称
+func pageRevisionsDocument(id string) string {
+	if id == defaultPageID {
+		return storageRevisions
+	}
+	return storageRevisions + "." + id
+}
+
+// personalDocument 返回用户在页面上的个人导航板在存储中的名称
+func personalDocument(id string, username string) string {
+	return pageDocument(id) + "." + username
+}
+
+var pageStore *PageStore
+
+// PageStore 管理导航页列表，并缓存已打开页面的数据
+type PageStore struct {
+	mu      sync.RWMutex
+	pages   []Page
+	storage Storage
+	opened  map[string]*pageData // 按页面 id
+}
+
+// pageData 一个页面的导航数据
+type pageData struct {
+	id        string
+	nav       *NavigationStore
+	revisions *RevisionStore
+
+	mu       sync.Mutex
+	personal map[string]*NavigationStore // 按用户名
+}
+
+func NewPageStore(storage Storage) *PageStore {
+	ps := &PageStore{
+		pages:   []Page{{ID: defaultPageID, Slug: "home", Title: "Home"}},
+		storage: storage,
+		opened:  make(map[string]*pageData),
+	}
+
+	data, err := storage.Load(storagePages)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading pages: %v", err)
+		}
+		return ps
+	}
+	var pages []Page
+	if err := json.Unmarshal(data, &pages); err != nil {
+		log.Printf("Error unmarshaling pages: %v", err)
+		return ps
+	}
+	if len(pages) > 0 {
+		ps.pages = pages
+	}
+	return ps
+}
+
+func (ps *PageStore) save() error {
+	data, err := json.MarshalIndent(ps.pages, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling pages: %v", err)
+	}
+	return ps.storage.Save(storagePages, data)
+}
+
+// List 返回所有页面，按显示顺序排列
+func (ps *PageStore) List() []Page {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	return append([]Page(nil), ps.pages...)
+}
+
+// First 返回第一个页面，不带 /p/<slug> 前缀的请求都属于它
+func (ps *PageStore) First() Page {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	return ps.pages[0]
+}
+
+// Get 根据 slug 查找页面
+func (ps *PageStore) Get(slug string) (Page, bool) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	index := ps.find(slug)
+	if index < 0 {
+		return Page{}, false
+	}
+	return ps.pages[index], true
+}
+
+func (ps *PageStore) find(slug string) int {
+	for i, page := range ps.pages {
+		if page.Slug == slug {
+			return i
+		}
+	}
+	return -1
+}
+
+// Create 新增页面，追加到最后
+func (ps *PageStore) Create(slug string, title string) (Page, error) {
+	if !validPageSlug.MatchString(slug) {
+		return Page{}, newRequestError(http.StatusBadRequest, "Invalid slug, use lowercase letters, digits and '-'")
+	}
+	if title == "" {
+		title = slug
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.find(slug) >= 0 {
+		return Page{}, newRequestError(http.StatusConflict, "Page '%s' already exists", slug)
+	}
+	page := Page{ID: generateID(), Slug: slug, Title: title}
+	ps.pages = append(ps.pages, page)
+	if err := ps.save(); err != nil {
+		ps.pages = ps.pages[:len(ps.pages)-1]
+		return Page{}, err
+	}
+	return page, nil
+}
+
+// Rename 修改页面的 slug 和标题，参数为空时保持不变
+func (ps *PageStore) Rename(slug string, newSlug string, title string) (Page, error) {
+	if newSlug != "" && !validPageSlug.MatchString(newSlug) {
+		return Page{}, newRequestError(http.StatusBadRequest, "Invalid slug, use lowercase letters, digits and '-'")
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	index := ps.find(slug)
+	if index < 0 {
+		return Page{}, newRequestError(http.StatusNotFound, "Page not found")
+	}
+	if newSlug != "" && newSlug != slug && ps.find(newSlug) >= 0 {
+		return Page{}, newRequestError(http.StatusConflict, "Page '%s' already exists", newSlug)
+	}
+	old := ps.pages[index]
+	page := old
+	if newSlug != "" {
+		page.Slug = newSlug
+	}
+	if title != "" {
+		page.Title = title
+	}
+	ps.pages[index] = page
+	if err := ps.save(); err != nil {
+		ps.pages[index] = old
+		return Page{}, err
+	}
+	return page, nil
+}
+
+// Reorder 按 slugs 的顺序重新排列页面，slugs 必须包含所有页面
+func (ps *PageStore) Reorder(slugs []string) ([]Page, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if len(slugs) != len(ps.pages) {
+		return nil, newRequestError(http.StatusBadRequest, "All pages must be listed")
+	}
+	pages := make([]Page, 0, len(slugs))
+	seen := make(map[string]bool)
+	for _, slug := range slugs {
+		index := ps.find(slug)
+		if index < 0 || seen[slug] {
+			return nil, newRequestError(http.StatusBadRequest, "Invalid page: %s", slug)
+		}
+		seen[slug] = true
+		pages = append(pages, ps.pages[index])
+	}
+	old := ps.pages
+	ps.pages = pages
+	if err := ps.save(); err != nil {
+		ps.pages = old
+		return nil, err
+	}
+	return append([]Page(nil), pages...), nil
+}
+
+// Delete 删除页面，至少要保留一个页面。页面的数据仍留在存储中，不会被删除
+func (ps *PageStore) Delete(slug string) (Page, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	index := ps.find(slug)
+	if index < 0 {
+		return Page{}, newRequestError(http.StatusNotFound, "Page not found")
+	}
+	if len(ps.pages) == 1 {
+		return Page{}, newRequestError(http.StatusBadRequest, "Cannot delete the last page")
+	}
+	page := ps.pages[index]
+	old := ps.pages
+	ps.pages = append(append([]Page(nil), ps.pages[:index]...), ps.pages[index+1:]...)
+	if err := ps.save(); err != nil {
+		ps.pages = old
+		return Page{}, err
+	}
+	delete(ps.opened, page.ID)
+	return page, nil
+}
+
+// Open 返回页面的数据，第一次访问时创建
+func (ps *PageStore) Open(page Page) *pageData {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	data, ok := ps.opened[page.ID]
+	if !ok {
+		revisions := NewRevisionStore(ps.storage, pageRevisionsDocument(page.ID), envMaxRevisions)
+		data = &pageData{
+			id:        page.ID,
+			nav:       NewNavigationStore(ps.storage, pageDocument(page.ID), revisions),
+			revisions: revisions,
+			personal:  make(map[string]*NavigationStore),
+		}
+		ps.opened[page.ID] = data
+	}
+	return data
+}
+
+// Personal 返回用户在该页面上的个人导航板，个人导航板不记录历史版本
+func (pd *pageData) Personal(username string) *NavigationStore {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	store, ok := pd.personal[username]
+	if !ok {
+		store = NewNavigationStore(dataStorage, personalDocument(pd.id, username), nil)
+		pd.personal[username] = store
+	}
+	return store
+}
+
+const pageContextKey contextKey = "page"
+
+// requestPage 返回请求所属的页面：/p/<slug>/ 下的请求属于对应页面，其他请求属于第一个页面
+func requestPage(r *http.Request) Page {
+	if page, ok := r.Context().Value(pageContextKey).(Page); ok {
+		return page
+	}
+	return pageStore.First()
+}
+
+// splitPagePath 把 /p/<slug>/rest 拆分为 slug 和 /rest，不是页面路径时返回 false
+func splitPagePath(path string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(path, "/p/")
+	if !ok {
+		return "", path, false
+	}
+	slug := rest
+	if i := strings.Index(rest, "/"); i >= 0 {
+		slug, rest = rest[:i], rest[i:]
+	} else {
+		rest = "/"
+	}
+	return slug, rest, true
+}
+
+// pagePrefixHandler 处理 /p/<slug>/ 下的请求：去掉前缀后交给 next，
+// 这样 /p/<slug>/navigation/... 就是该页面的接口，其他路径返回前端页面
+func pagePrefixHandler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slug, rest, _ := splitPagePath(r.URL.Path)
+		page, ok := pageStore.Get(slug)
+		if !ok || strings.HasPrefix(rest, "/p/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), pageContextKey, page))
+		r.URL.Path = rest
+		r.URL.RawPath = ""
+		next.ServeHTTP(w, r)
+	}
+}
+
+// PageRequest 新增/修改页面的请求体
+type PageRequest struct {
+	Slug  string   `json:"slug"`
+	Title string   `json:"title"`
+	Slugs []string `json:"slugs"` // 调整顺序时使用
+}
+
+// pagesHandler 页面管理接口:
+//
+//	GET    /pages         列出页面
+//	POST   /pages         新增页面
+//	PUT    /pages         调整顺序，请求体为 {"slugs": [...]}
+//	PUT    /pages/{slug}  修改 slug 或标题
+//	DELETE /pages/{slug}  删除页面
+func pagesHandler(w http.ResponseWriter, r *http.Request) {
+	slug := strings.Trim(strings.TrimPrefix(r.URL.Path, "/pages"), "/")
+
+	if r.Method != http.MethodGet {
+		info, ok := requestAuth(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !hasRole(info.Role, roleEditor) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	var req PageRequest
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch {
+	case slug == "" && r.Method == http.MethodGet:
+		writeJSON(w, pageStore.List())
+	case slug == "" && r.Method == http.MethodPost:
+		page, err := pageStore.Create(req.Slug, req.Title)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Page created: %s (%s)", page.Slug, page.ID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(page)
+	case slug == "" && r.Method == http.MethodPut:
+		pages, err := pageStore.Reorder(req.Slugs)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, pages)
+	case slug != "" && r.Method == http.MethodPut:
+		page, err := pageStore.Rename(slug, req.Slug, req.Title)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, page)
+	case slug != "" && r.Method == http.MethodDelete:
+		page, err := pageStore.Delete(slug)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Page deleted: %s (%s), its data is kept in storage", page.Slug, page.ID)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
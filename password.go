@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+)
+
+// argon2id 参数，参考 RFC 9106 的推荐值
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 2
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+
+	// 校验哈希时接受的参数范围，防止配置错误的哈希导致 panic 或占用过多内存和 CPU
+	argon2MaxTime    = 16
+	argon2MaxMemory  = 1024 * 1024 // KiB，即 1 GiB
+	argon2MaxThreads = 16
+	argon2MinKeyLen  = 16
+	argon2MaxKeyLen  = 64
+	argon2MinSaltLen = 8
+)
+
+// checkCredentials 校验用户名和密码，比较时间与内容无关
+func checkCredentials(username string, password string) bool {
+	userOK := constantTimeEqual(username, envUsername)
+	passOK := false
+	if envPasswordHash != "" {
+		passOK = verifyPasswordHash(password, envPasswordHash)
+	} else if envPassword != "" {
+		passOK = constantTimeEqual(password, envPassword)
+	}
+	return userOK && passOK
+}
+
+// constantTimeEqual 先做 SHA-256 再比较，避免通过耗时泄露长度
+func constantTimeEqual(a string, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// verifyPasswordHash 校验 bcrypt 或 argon2id 哈希
+func verifyPasswordHash(password string, hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		ok, err := verifyArgon2id(password, hash)
+		return err == nil && ok
+	default:
+		return false
+	}
+}
+
+// validatePasswordHash 检查哈希格式是否受支持，用于启动时提示配置错误
+func validatePasswordHash(hash string) error {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	case strings.HasPrefix(hash, "$argon2id$"):
+		_, err := verifyArgon2id("", hash)
+		return err
+	default:
+		return errors.New("unsupported hash format, expected bcrypt or argon2id")
+	}
+}
+
+// hashArgon2id 生成 PHC 格式的 argon2id 哈希:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func hashArgon2id(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func verifyArgon2id(password string, hash string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, errors.New("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, errors.New("invalid argon2id version")
+	}
+	if version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2id version: %d", version)
+	}
+	var memory uint32
+	var time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, errors.New("invalid argon2id parameters")
+	}
+	// t=0 或 p=0 时 argon2.IDKey 会 panic，m 至少为 8*p KiB
+	if time < 1 || time > argon2MaxTime || threads < 1 || threads > argon2MaxThreads ||
+		memory < 8*uint32(threads) || memory > argon2MaxMemory {
+		return false, fmt.Errorf("argon2id parameters out of range: m=%d,t=%d,p=%d", memory, time, threads)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) < argon2MinSaltLen {
+		return false, errors.New("invalid argon2id salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < argon2MinKeyLen || len(key) > argon2MaxKeyLen {
+		return false, errors.New("invalid argon2id key")
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+// runHashPasswordCommand 实现 `tiny-nav hash-password`：从标准输入读取密码，输出可填入 NAV_PASSWORD_HASH 的哈希
+func runHashPasswordCommand(args []string) {
+	fs := flag.NewFlagSet("hash-password", flag.ExitOnError)
+	algo := fs.String("algo", "bcrypt", "Hash algorithm: bcrypt or argon2id")
+	fs.Parse(args)
+
+	password, err := readPassword()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nFailed to read password: %v\n", err)
+		os.Exit(1)
+	}
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "\nPassword must not be empty")
+		os.Exit(1)
+	}
+
+	var hash string
+	switch *algo {
+	case "bcrypt":
+		var b []byte
+		b, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		hash = string(b)
+	case "argon2id":
+		hash, err = hashArgon2id(password)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown algorithm: %s\n", *algo)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to hash password: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(hash)
+}
+
+// readPassword 从标准输入读取一行密码。标准输入是终端时关闭回显，否则（如管道输入）直接读取
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
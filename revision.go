@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRevisionCount = 20 // 默认保留的历史版本数量
+
+// Change 描述一次修改，用于记录历史版本
+type Change struct {
+	Author string // 修改者
+	Action string // 修改类型: add, update, delete, sort, categories, restore, rollback
+}
+
+// Revision 导航数据的一个历史版本
+type Revision struct {
+	ID         int64       `json:"id"`
+	CreatedAt  int64       `json:"createdAt"` // 毫秒时间戳
+	Author     string      `json:"author"`
+	Action     string      `json:"action"`
+	Navigation *Navigation `json:"navigation,omitempty"`
+}
+
+// RevisionStore 保存一个页面最近的若干个导航数据版本
+type RevisionStore struct {
+	mu        sync.Mutex
+	revisions []Revision
+	limit     int
+	storage   Storage
+	document  string // 存储中的名称
+}
+
+func NewRevisionStore(storage Storage, document string, limit int) *RevisionStore {
+	if limit <= 0 {
+		limit = defaultRevisionCount
+	}
+	rs := &RevisionStore{storage: storage, document: document, limit: limit}
+
+	data, err := storage.Load(document)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading revisions: %v", err)
+		}
+		return rs
+	}
+	if err := json.Unmarshal(data, &rs.revisions); err != nil {
+		log.Printf("Error unmarshaling revisions: %v", err)
+		rs.revisions = nil
+	}
+	return rs
+}
+
+// Record 记录修改前后的数据。没有任何历史时先把修改前的数据记为初始版本，
+// 这样第一次修改也可以撤销。
+func (rs *RevisionStore) Record(before Navigation, after Navigation, change Change) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if len(rs.revisions) == 0 {
+		createdAt := before.LastModified
+		if createdAt == 0 {
+			createdAt = now
+		}
+		rs.append(before, Change{Author: "system", Action: "initial"}, createdAt)
+	}
+	rs.append(after, change, now)
+
+	// 只保留最近的 limit 个版本
+	if len(rs.revisions) > rs.limit {
+		rs.revisions = append([]Revision(nil), rs.revisions[len(rs.revisions)-rs.limit:]...)
+	}
+
+	data, err := json.Marshal(rs.revisions)
+	if err != nil {
+		return fmt.Errorf("error marshaling revisions: %v", err)
+	}
+	return rs.storage.Save(rs.document, data)
+}
+
+func (rs *RevisionStore) append(nav Navigation, change Change, createdAt int64) {
+	var id int64 = 1
+	if n := len(rs.revisions); n > 0 {
+		id = rs.revisions[n-1].ID + 1
+	}
+	snapshot := nav
+	rs.revisions = append(rs.revisions, Revision{
+		ID:         id,
+		CreatedAt:  createdAt,
+		Author:     change.Author,
+		Action:     change.Action,
+		Navigation: &snapshot,
+	})
+}
+
+// List 返回所有版本（不含数据），最新的在前
+func (rs *RevisionStore) List() []Revision {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	list := make([]Revision, 0, len(rs.revisions))
+	for i := len(rs.revisions) - 1; i >= 0; i-- {
+		rev := rs.revisions[i]
+		rev.Navigation = nil
+		list = append(list, rev)
+	}
+	return list
+}
+
+// Get 返回指定版本，不存在时第二个返回值为 false
+func (rs *RevisionStore) Get(id int64) (Revision, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for _, rev := range rs.revisions {
+		if rev.ID == id {
+			return rev, true
+		}
+	}
+	return Revision{}, false
+}
+
+// Latest 返回最新的版本，没有历史时第二个返回值为 false
+func (rs *RevisionStore) Latest() (Revision, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if len(rs.revisions) == 0 {
+		return Revision{}, false
+	}
+	return rs.revisions[len(rs.revisions)-1], true
+}
+
+// requestAuthor 返回请求的修改者，用 token 指纹区分同一用户的不同登录会话
+func requestAuthor(r *http.Request) string {
+	info, ok := requestAuth(r)
+	if !ok {
+		return "anonymous"
+	}
+	name := info.Username
+	if name == "" {
+		name = "user"
+	}
+	return fmt.Sprintf("%s#%s", name, tokenFingerprint(info.Token))
+}
+
+// tokenFingerprint 返回 token 的短指纹，避免在日志和历史中暴露 token
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:4])
+}
+
+// Restorer 返回把共享导航板恢复为指定版本的修改函数，恢复本身也会记录为一个新版本
+func (rs *RevisionStore) Restorer(id int64) (func(nav *Navigation) error, error) {
+	rev, ok := rs.Get(id)
+	if !ok || rev.Navigation == nil {
+		return nil, newRequestError(http.StatusNotFound, "Revision not found: %d", id)
+	}
+	snapshot, err := copyNavigation(*rev.Navigation)
+	if err != nil {
+		return nil, err
+	}
+	return func(nav *Navigation) error {
+		nav.Links = snapshot.Links
+		nav.Categories = snapshot.Categories
+		ensureLinkIDs(nav)
+		return nil
+	}, nil
+}
+
+// LinkChange 一个链接修改前后的内容
+type LinkChange struct {
+	Before Link `json:"before"`
+	After  Link `json:"after"`
+}
+
+// RevisionDiff 两个版本之间的差异
+type RevisionDiff struct {
+	From             int64        `json:"from"`
+	To               int64        `json:"to"`
+	Added            []Link       `json:"added"`
+	Removed          []Link       `json:"removed"`
+	Changed          []LinkChange `json:"changed"`
+	CategoriesBefore []string     `json:"categoriesBefore,omitempty"` // 分类顺序有变化时才返回
+	CategoriesAfter  []string     `json:"categoriesAfter,omitempty"`
+}
+
+// diffNavigation 按链接 id 比较两个版本
+func diffNavigation(from *Navigation, to *Navigation) RevisionDiff {
+	diff := RevisionDiff{
+		Added:   make([]Link, 0),
+		Removed: make([]Link, 0),
+		Changed: make([]LinkChange, 0),
+	}
+
+	before := make(map[string]Link, len(from.Links))
+	for _, link := range from.Links {
+		before[link.ID] = link
+	}
+	for _, link := range to.Links {
+		old, exists := before[link.ID]
+		if !exists {
+			diff.Added = append(diff.Added, link)
+			continue
+		}
+		if old != link {
+			diff.Changed = append(diff.Changed, LinkChange{Before: old, After: link})
+		}
+		delete(before, link.ID)
+	}
+	for _, link := range from.Links {
+		if _, removed := before[link.ID]; removed {
+			diff.Removed = append(diff.Removed, link)
+		}
+	}
+
+	if !reflect.DeepEqual(from.Categories, to.Categories) {
+		diff.CategoriesBefore = from.Categories
+		diff.CategoriesAfter = to.Categories
+	}
+	return diff
+}
+
+// revisionsHandler 历史版本接口:
+//
+//	GET  /navigation/revisions                   列出历史版本
+//	GET  /navigation/revisions/diff?from=1&to=2  比较两个版本，to 默认为最新版本
+//	GET  /navigation/revisions/{id}              查看某个版本的数据
+//	POST /navigation/revisions/{id}/restore      恢复到某个版本
+func revisionsHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/navigation/revisions"), "/")
+	revisionStore := pageStore.Open(requestPage(r)).revisions
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, revisionStore.List())
+	case path == "diff":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		diffRevisionsHandler(w, r, revisionStore)
+	case len(parts) == 1:
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid revision id", http.StatusBadRequest)
+			return
+		}
+		rev, ok := revisionStore.Get(id)
+		if !ok {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, rev)
+	case len(parts) == 2 && parts[1] == "restore":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if info, _ := requestAuth(r); !hasRole(info.Role, roleEditor) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		id, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid revision id", http.StatusBadRequest)
+			return
+		}
+		restore, err := revisionStore.Restorer(id)
+		if err != nil {
+			writeNavigationError(w, nil, err)
+			return
+		}
+		nav, err := requestView(r).Update(boardShared, 0, Change{Author: requestAuthor(r), Action: "restore"}, restore)
+		if err != nil {
+			writeNavigationError(w, &nav, err)
+			return
+		}
+		w.Header().Set("ETag", navigationETag(&nav))
+		writeJSON(w, nav)
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+}
+
+func diffRevisionsHandler(w http.ResponseWriter, r *http.Request, revisionStore *RevisionStore) {
+	query := r.URL.Query()
+	fromID, err := strconv.ParseInt(query.Get("from"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid from revision", http.StatusBadRequest)
+		return
+	}
+	from, ok := revisionStore.Get(fromID)
+	if !ok {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	var to Revision
+	if v := query.Get("to"); v != "" {
+		toID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid to revision", http.StatusBadRequest)
+			return
+		}
+		if to, ok = revisionStore.Get(toID); !ok {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+	} else {
+		to, _ = revisionStore.Latest()
+	}
+
+	diff := diffNavigation(from.Navigation, to.Navigation)
+	diff.From = from.ID
+	diff.To = to.ID
+	writeJSON(w, diff)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// 安全响应头配置
+var (
+	envSecurityHeaders       bool   // 为 false 时不输出任何安全响应头
+	envContentSecurityPolicy string // 自定义 CSP，为空时使用默认策略，off 表示不输出
+	envFrameAncestors        string // 允许嵌入页面的来源，'none' 表示禁止
+	envReferrerPolicy        string
+	envPermissionsPolicy     string
+	envHSTSMaxAge            int // 秒，0 表示不输出 HSTS
+	envHSTSIncludeSubdomains bool
+)
+
+const defaultPermissionsPolicy = "accelerometer=(), camera=(), geolocation=(), gyroscope=(), magnetometer=(), microphone=(), payment=(), usb=()"
+
+var securityHeaders map[string]string
+
+// inlineScriptPattern 匹配不带 src 的内联脚本
+var inlineScriptPattern = regexp.MustCompile(`(?is)<script\b([^>]*)>(.*?)</script>`)
+
+// inlineScriptHashes 计算 index.html 中内联脚本（如主题初始化脚本）的 CSP 哈希，
+// 这样 script-src 不需要 'unsafe-inline'
+func inlineScriptHashes(static fs.FS) []string {
+	data, err := fs.ReadFile(static, "index.html")
+	if err != nil {
+		return nil
+	}
+	var hashes []string
+	for _, m := range inlineScriptPattern.FindAllSubmatch(data, -1) {
+		if strings.Contains(strings.ToLower(string(m[1])), "src=") {
+			continue
+		}
+		sum := sha256.Sum256(m[2])
+		hashes = append(hashes, "'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'")
+	}
+	return hashes
+}
+
+// defaultContentSecurityPolicy 默认的 CSP：脚本只允许自身和 index.html 中的内联脚本，
+// 图标可以是 data: URI 或外部图片地址；样式允许内联（拖拽排序和 SVG 图标会设置 style）
+func defaultContentSecurityPolicy(static fs.FS) string {
+	scriptSrc := append([]string{"'self'"}, inlineScriptHashes(static)...)
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src " + strings.Join(scriptSrc, " "),
+		"style-src 'self' 'unsafe-inline'",
+		"img-src 'self' data: https: http:",
+		"font-src 'self' data:",
+		"connect-src 'self'",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors " + envFrameAncestors,
+	}, "; ")
+}
+
+// initSecurityHeaders 根据配置生成所有请求共用的安全响应头
+func initSecurityHeaders(static fs.FS) {
+	if !envSecurityHeaders {
+		return
+	}
+	securityHeaders = map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"Referrer-Policy":        envReferrerPolicy,
+		"Permissions-Policy":     envPermissionsPolicy,
+	}
+	switch envContentSecurityPolicy {
+	case "off":
+	case "":
+		securityHeaders["Content-Security-Policy"] = defaultContentSecurityPolicy(static)
+	default:
+		securityHeaders["Content-Security-Policy"] = envContentSecurityPolicy
+	}
+	// 不支持 frame-ancestors 的旧浏览器
+	if envFrameAncestors == "'none'" {
+		securityHeaders["X-Frame-Options"] = "DENY"
+	} else if envFrameAncestors == "'self'" {
+		securityHeaders["X-Frame-Options"] = "SAMEORIGIN"
+	}
+	// HSTS 需要主动开启：一旦浏览器记住，在有效期内就无法再用 HTTP 访问
+	if envHSTSMaxAge > 0 {
+		hsts := fmt.Sprintf("max-age=%d", envHSTSMaxAge)
+		if envHSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		securityHeaders["Strict-Transport-Security"] = hsts
+	}
+	for name, value := range securityHeaders {
+		if value == "" {
+			delete(securityHeaders, name)
+		}
+	}
+	log.Printf("Security headers enabled: %s", securityHeaders["Content-Security-Policy"])
+}
+
+// 安全响应头中间件
+func securityHeadersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range securityHeaders {
+			w.Header().Set(name, value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Session 登录会话的信息，不包含 token 本身
+type Session struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	CreatedAt  int64  `json:"createdAt,omitempty"` // Unix 秒，会话管理之前登录的为 0
+	LastUsedAt int64  `json:"lastUsedAt,omitempty"`
+	ExpireAt   int64  `json:"expireAt"`
+	IP         string `json:"ip,omitempty"`
+	UserAgent  string `json:"userAgent,omitempty"`
+	Current    bool   `json:"current"` // 是否为发起请求的会话
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// Sessions 返回 username 的会话，all 为 true 时返回所有用户的会话。current 为当前请求的 token
+func (ts *TokenStore) Sessions(username string, all bool, current string) []Session {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	now := time.Now()
+	currentHash := hashToken(current)
+	list := make([]Session, 0)
+	for k, t := range ts.tokens {
+		if now.After(t.ExpireAt) || (!all && t.Username != username) {
+			continue
+		}
+		list = append(list, Session{
+			ID:         t.ID,
+			Username:   t.Username,
+			CreatedAt:  unixOrZero(t.CreatedAt),
+			LastUsedAt: unixOrZero(t.LastUsedAt),
+			ExpireAt:   t.ExpireAt.Unix(),
+			IP:         t.IP,
+			UserAgent:  t.UserAgent,
+			Current:    current != "" && k == currentHash,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastUsedAt > list[j].LastUsedAt
+	})
+	return list
+}
+
+// Revoke 使 token 失效，token 不存在时返回 false
+func (ts *TokenStore) Revoke(token string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	hash := hashToken(token)
+	if _, ok := ts.tokens[hash]; !ok {
+		return false
+	}
+	delete(ts.tokens, hash)
+	if err := ts.saveTokens(); err != nil {
+		log.Printf("Error saving tokens: %v", err)
+	}
+	return true
+}
+
+// RevokeSession 按会话 id 注销会话。all 为 false 时只能注销 username 自己的会话
+func (ts *TokenStore) RevokeSession(id string, username string, all bool) (Session, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for k, t := range ts.tokens {
+		if t.ID != id || (!all && t.Username != username) {
+			continue
+		}
+		delete(ts.tokens, k)
+		if err := ts.saveTokens(); err != nil {
+			return Session{}, err
+		}
+		return Session{ID: t.ID, Username: t.Username}, nil
+	}
+	return Session{}, newRequestError(http.StatusNotFound, "Session not found")
+}
+
+// logoutHandler 注销当前请求使用的登录令牌
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	info, _ := requestAuth(r)
+	if info.Scopes != nil {
+		http.Error(w, "Access tokens cannot log out, revoke them via /tokens", http.StatusBadRequest)
+		return
+	}
+	if info.Forwarded {
+		http.Error(w, "Authenticated by the reverse proxy, log out there", http.StatusBadRequest)
+		return
+	}
+	tokenStore.Revoke(info.Token)
+	if info.Cookie {
+		clearSessionCookies(w)
+	}
+	log.Printf("Logout: user=%q ip=%s", info.Username, clientIP(r))
+	w.WriteHeader(http.StatusOK)
+}
+
+// sessionsHandler 登录会话管理接口，只能用登录令牌调用:
+//
+//	GET    /sessions       列出自己的会话，管理员列出所有用户的
+//	DELETE /sessions/{id}  注销会话
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	info, _ := requestAuth(r)
+	if info.Scopes != nil {
+		http.Error(w, "Access tokens cannot manage sessions", http.StatusForbidden)
+		return
+	}
+	isAdmin := hasRole(info.Role, roleAdmin)
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/sessions"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		writeJSON(w, tokenStore.Sessions(info.Username, isAdmin, info.Token))
+	case id != "" && r.Method == http.MethodDelete:
+		session, err := tokenStore.RevokeSession(id, info.Username, isAdmin)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Session revoked: id=%s user=%q by user=%q", session.ID, session.Username, info.Username)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+const (
+	sessionCookieName = "tiny_nav_session"
+	csrfCookieName    = "tiny_nav_csrf" // 前端可读，修改类请求通过 X-CSRF-Token 请求头回传
+	csrfHeaderName    = "X-CSRF-Token"
+)
+
+var envSessionCookie bool       // 登录令牌放在 HttpOnly cookie 中，而不是 Authorization 响应头
+var envSessionCookieSecure bool // cookie 是否只通过 HTTPS 发送
+
+// issueSession 返回登录得到的令牌：启用 SESSION_COOKIE 时写入 cookie，否则放在 Authorization 响应头
+func issueSession(w http.ResponseWriter, r *http.Request, token string) {
+	if envSessionCookie {
+		setSessionCookies(w, token)
+	} else {
+		w.Header().Set("Authorization", token)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// csrfToken 由会话令牌派生 CSRF 令牌，不需要额外保存；拿不到 HttpOnly 的会话令牌就无法伪造
+func csrfToken(token string) string {
+	return hashToken("csrf:" + token)
+}
+
+// validCSRF 校验 cookie 会话的修改类请求是否带有正确的 CSRF 令牌
+func validCSRF(r *http.Request, token string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return constantTimeEqual(r.Header.Get(csrfHeaderName), csrfToken(token))
+}
+
+func setSessionCookies(w http.ResponseWriter, token string) {
+	maxAge := int(defaultExpireTime / time.Second)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   envSessionCookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    csrfToken(token),
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   envSessionCookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{sessionCookieName, csrfCookieName} {
+		http.SetCookie(w, &http.Cookie{Name: name, Path: "/", MaxAge: -1, Secure: envSessionCookieSecure})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	storageDriverJSON   = "json"
+	storageDriverSQLite = "sqlite"
+	sqliteFileName      = "tiny-nav.db"
+
+	// 存储中各类数据的名称
+	storageNavigation = "navigation"
+	storageTokens     = "tokens"
+	storageSettings   = "settings"
+	storageRevisions  = "revisions"
+	storageUsers      = "users"
+	storagePages      = "pages"
+	storageAPITokens  = "apitokens"
+	storageTOTP       = "totp"
+	storagePagePrefix = "page." // 新增页面的导航数据为 page.<页面 id>
+)
+
+// 需要从 JSON 文件迁移到其他存储的数据
+var storageDocuments = []string{storageNavigation, storageTokens, storageSettings, storageRevisions, storageUsers, storagePages, storageAPITokens, storageTOTP}
+
+// 包含令牌或密码哈希的数据，文件只允许所有者读写
+var storagePrivateDocuments = map[string]bool{storageTokens: true, storageAPITokens: true, storageUsers: true, storageTOTP: true}
+
+// 名称不固定的数据（页面、个人导航板、页面的历史版本），迁移时按文件名匹配
+var storageDocumentPatterns = []string{storageNavigation + ".*", storagePagePrefix + "*", storageRevisions + ".*"}
+
+var dataStorage Storage
+
+// Storage 持久化后端，按名称读写整份数据（导航、token、设置等）
+type Storage interface {
+	// Load 读取数据，不存在时返回 os.ErrNotExist
+	Load(name string) ([]byte, error)
+	// Save 原子地保存数据
+	Save(name string, data []byte) error
+	// Version 返回数据当前的版本标识，数据被修改后随之变化，不存在时返回空字符串
+	Version(name string) (string, error)
+	Close() error
+}
+
+// openStorage 根据配置的驱动打开存储
+func openStorage(driver string) (Storage, error) {
+	switch driver {
+	case "", storageDriverJSON:
+		return newFileStorage(dataDir), nil
+	case storageDriverSQLite:
+		s, err := newSQLiteStorage(filepath.Join(dataDir, sqliteFileName))
+		if err != nil {
+			return nil, err
+		}
+		if err := migrateFromFiles(s); err != nil {
+			s.Close()
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %s", driver)
+	}
+}
+
+// fileStorage 默认的 JSON 文件存储，每份数据对应 dataDir 下的 <name>.json
+type fileStorage struct {
+	dir string
+}
+
+func newFileStorage(dir string) *fileStorage {
+	// 确保 data 目录存在
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Failed to create data directory: %v", err)
+	}
+	return &fileStorage{dir: dir}
+}
+
+func (s *fileStorage) path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+func (s *fileStorage) Load(name string) ([]byte, error) {
+	return os.ReadFile(s.path(name))
+}
+
+func (s *fileStorage) Save(name string, data []byte) error {
+	if storagePrivateDocuments[name] {
+		return writeFileAtomic(s.path(name), data, 0600)
+	}
+	return writeFileAtomic(s.path(name), data, 0644)
+}
+
+// Version 使用文件的修改时间和大小作为版本，手动编辑文件也能被发现
+func (s *fileStorage) Version(name string) (string, error) {
+	info, err := os.Stat(s.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()), nil
+}
+
+func (s *fileStorage) Close() error {
+	return nil
+}
+
+// sqliteStorage 内嵌 SQLite 存储（纯 Go 实现，不依赖 CGO）
+type sqliteStorage struct {
+	db *sql.DB
+}
+
+func newSQLiteStorage(path string) (*sqliteStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+	// 数据库中包含令牌和密码哈希，与 JSON 存储的私有文件一样只允许所有者读写。
+	// 先创建文件再打开，SQLite 创建 -wal 和 -shm 文件时沿用数据库文件的权限
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sqlite database: %v", err)
+	}
+	f.Close()
+	if err := os.Chmod(path, 0600); err != nil {
+		return nil, fmt.Errorf("failed to restrict sqlite database permissions: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %v", err)
+	}
+	// SQLite 只允许一个写连接
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS documents (
+		name    TEXT PRIMARY KEY,
+		data    BLOB NOT NULL,
+		version INTEGER NOT NULL DEFAULT 1
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize sqlite database: %v", err)
+	}
+	// 旧版本创建的 -wal 和 -shm 文件可能是默认权限
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Chmod(path+suffix, 0600); err != nil && !os.IsNotExist(err) {
+			db.Close()
+			return nil, fmt.Errorf("failed to restrict sqlite database permissions: %v", err)
+		}
+	}
+	return &sqliteStorage{db: db}, nil
+}
+
+func (s *sqliteStorage) Load(name string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM documents WHERE name = ?`, name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, os.ErrNotExist
+	}
+	return data, err
+}
+
+func (s *sqliteStorage) Save(name string, data []byte) error {
+	_, err := s.db.Exec(`INSERT INTO documents (name, data) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET data = excluded.data, version = documents.version + 1`, name, data)
+	return err
+}
+
+func (s *sqliteStorage) Version(name string) (string, error) {
+	var version int64
+	err := s.db.QueryRow(`SELECT version FROM documents WHERE name = ?`, name).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d", version), nil
+}
+
+func (s *sqliteStorage) Close() error {
+	return s.db.Close()
+}
+
+// migrateFromFiles 新建的数据库为空时，一次性导入 data 目录下已有的 JSON 文件
+func migrateFromFiles(dst Storage) error {
+	for _, name := range storageDocuments {
+		version, err := dst.Version(name)
+		if err != nil {
+			return err
+		}
+		if version != "" {
+			// 数据库中已有数据，说明已经迁移过
+			return nil
+		}
+	}
+
+	src := newFileStorage(dataDir)
+	names := append([]string{}, storageDocuments...)
+	for _, pattern := range storageDocumentPatterns {
+		files, err := filepath.Glob(filepath.Join(dataDir, pattern+".json"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			names = append(names, strings.TrimSuffix(filepath.Base(file), ".json"))
+		}
+	}
+	migrated := 0
+	for _, name := range names {
+		data, err := src.Load(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read %s: %v", src.path(name), err)
+		}
+		if err := dst.Save(name, data); err != nil {
+			return fmt.Errorf("failed to migrate %s: %v", name, err)
+		}
+		migrated++
+	}
+	if migrated > 0 {
+		log.Printf("Migrated %d data files from %s into %s", migrated, dataDir, sqliteFileName)
+	}
+	return nil
+}
+
+var settingsStore *SettingsStore
+
+// SettingsStore 保存运行时设置（键值对）
+type SettingsStore struct {
+	mu       sync.Mutex
+	settings map[string]string
+	storage  Storage
+}
+
+func NewSettingsStore(storage Storage) *SettingsStore {
+	ss := &SettingsStore{
+		settings: make(map[string]string),
+		storage:  storage,
+	}
+
+	data, err := storage.Load(storageSettings)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading settings: %v", err)
+		}
+		return ss
+	}
+	if err := json.Unmarshal(data, &ss.settings); err != nil {
+		log.Printf("Error unmarshaling settings: %v", err)
+		ss.settings = make(map[string]string)
+	}
+	return ss
+}
+
+// Get 读取设置，不存在时返回 defaultValue
+func (ss *SettingsStore) Get(key string, defaultValue string) string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if v, ok := ss.settings[key]; ok {
+		return v
+	}
+	return defaultValue
+}
+
+// Set 修改设置并保存
+func (ss *SettingsStore) Set(key string, value string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.settings[key] = value
+	data, err := json.MarshalIndent(ss.settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling settings: %v", err)
+	}
+	return ss.storage.Save(storageSettings, data)
+}
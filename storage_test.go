@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 切换到 SQLite 存储并导入 JSON 数据后，数据库及其 -wal、-shm 文件不能比私有 JSON 文件的权限更宽
+func TestSQLiteMigrationKeepsFilesPrivate(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	src := newFileStorage(dataDir)
+	if err := src.Save(storageTokens, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	// 旧版本创建的数据库文件是默认权限
+	dbPath := filepath.Join(dataDir, sqliteFileName)
+	if err := os.WriteFile(dbPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := openStorage(storageDriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if data, err := s.Load(storageTokens); err != nil || string(data) != `{}` {
+		t.Fatalf("tokens not migrated: %q %v", data, err)
+	}
+
+	for _, path := range []string{dbPath, dbPath + "-wal", dbPath + "-shm"} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode&0077 != 0 {
+			t.Errorf("%s has mode %v, want 0600", filepath.Base(path), mode)
+		}
+	}
+}
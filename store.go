@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// NavigationStore 管理导航数据的读写，所有修改都串行执行。
+// 数据缓存在内存中，只有存储中的数据被外部修改（如手动编辑文件）时才重新读取。
+type NavigationStore struct {
+	mu        sync.RWMutex
+	storage   Storage
+	document  string         // 存储中的名称
+	revisions *RevisionStore // 为 nil 时不记录历史版本
+	nav       Navigation     // 缓存的导航数据，只整体替换，不原地修改
+	loaded    bool
+	version   string // 缓存对应的存储版本
+}
+
+func NewNavigationStore(storage Storage, document string, revisions *RevisionStore) *NavigationStore {
+	return &NavigationStore{storage: storage, document: document, revisions: revisions}
+}
+
+// Load 返回当前的导航数据，返回值与缓存共享，调用方不能修改
+func (s *NavigationStore) Load() (Navigation, error) {
+	s.mu.RLock()
+	if s.loaded && !s.changedOnDisk() {
+		nav := s.nav
+		s.mu.RUnlock()
+		return nav, nil
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.refresh(); err != nil {
+		return Navigation{}, err
+	}
+	return s.nav, nil
+}
+
+// Update 在锁内复制一份导航数据交给 fn 修改，fn 返回 nil 时保存并记录历史版本。
+// 返回值为保存后的数据；fn 返回错误时返回修改前的数据和该错误。
+func (s *NavigationStore) Update(change Change, fn func(nav *Navigation) error) (Navigation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.refresh(); err != nil {
+		return Navigation{}, err
+	}
+	nav, err := copyNavigation(s.nav)
+	if err != nil {
+		return Navigation{}, err
+	}
+	if err := fn(&nav); err != nil {
+		return s.nav, err
+	}
+	normalizeLinkIcons(&nav)
+	if err := s.save(&nav); err != nil {
+		return s.nav, err
+	}
+	if s.revisions != nil {
+		if err := s.revisions.Record(s.nav, nav, change); err != nil {
+			log.Printf("Failed to record revision: %v", err)
+		}
+	}
+	s.nav = nav
+	return nav, nil
+}
+
+// refresh 在缓存为空或文件被外部修改时重新读取，调用方需持有写锁
+func (s *NavigationStore) refresh() error {
+	if s.loaded && !s.changedOnDisk() {
+		return nil
+	}
+	if s.loaded {
+		log.Printf("Navigation %s changed in storage, reloading", s.document)
+	}
+	nav, err := s.load()
+	if err != nil {
+		return err
+	}
+	s.nav = nav
+	s.loaded = true
+	return nil
+}
+
+// changedOnDisk 通过存储的版本标识判断数据是否被外部修改
+func (s *NavigationStore) changedOnDisk() bool {
+	version, err := s.storage.Version(s.document)
+	if err != nil {
+		log.Printf("Failed to check navigation version: %v", err)
+		return true
+	}
+	return version != s.version
+}
+
+// recordVersion 记录存储中当前的版本标识
+func (s *NavigationStore) recordVersion() {
+	version, err := s.storage.Version(s.document)
+	if err != nil {
+		log.Printf("Failed to check navigation version: %v", err)
+	}
+	s.version = version
+}
+
+func (s *NavigationStore) load() (Navigation, error) {
+	// 先记录版本再读取，读取期间的外部修改会在下次检查时发现
+	s.recordVersion()
+	data, err := s.storage.Load(s.document)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return Navigation{}, err
+		}
+		return Navigation{}, nil
+	}
+	var nav Navigation
+	err = json.Unmarshal(data, &nav)
+	if err != nil {
+		return Navigation{}, err
+	}
+
+	// 旧数据没有 id，补齐后写回文件
+	if ensureLinkIDs(&nav) {
+		if err := s.save(&nav); err != nil {
+			return Navigation{}, fmt.Errorf("failed to migrate link ids: %v", err)
+		}
+		log.Printf("Migrated navigation %s: assigned ids to links", s.document)
+	}
+	// 旧数据的图标以 data URI 内嵌在链接中，移到图标缓存；SVG 代码去掉脚本，处理后写回文件
+	if normalizeLinkIcons(&nav) {
+		if err := s.save(&nav); err != nil {
+			return Navigation{}, fmt.Errorf("failed to migrate link icons: %v", err)
+		}
+		log.Printf("Migrated navigation %s: normalized link icons", s.document)
+	}
+	observeNavigationVersion(nav.LastModified)
+	return nav, nil
+}
+
+// save 保存导航数据，并更新 nav.LastModified 作为新版本号
+func (s *NavigationStore) save(nav *Navigation) error {
+	nav.LastModified = nextNavigationVersion(nav.LastModified)
+
+	data, err := json.MarshalIndent(nav, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.Save(s.document, data); err != nil {
+		return err
+	}
+	s.recordVersion()
+	return nil
+}
+
+// 所有导航板共用一个递增的版本号，这样合并视图的版本（各导航板版本的最大值）
+// 在任意一个导航板修改后都会变大
+var navigationVersion struct {
+	sync.Mutex
+	last int64
+}
+
+// nextNavigationVersion 返回新的版本号：当前毫秒时间戳，
+// 同一毫秒内的多次修改也要产生不同的版本号
+func nextNavigationVersion(current int64) int64 {
+	navigationVersion.Lock()
+	defer navigationVersion.Unlock()
+
+	version := time.Now().UnixNano() / int64(time.Millisecond)
+	if version <= current {
+		version = current + 1
+	}
+	if version <= navigationVersion.last {
+		version = navigationVersion.last + 1
+	}
+	navigationVersion.last = version
+	return version
+}
+
+// observeNavigationVersion 记录从存储读取到的版本号，之后生成的版本号都比它大
+func observeNavigationVersion(version int64) {
+	navigationVersion.Lock()
+	defer navigationVersion.Unlock()
+
+	if version > navigationVersion.last {
+		navigationVersion.last = version
+	}
+}
+
+// copyNavigation 深拷贝导航数据，避免修改到缓存
+func copyNavigation(nav Navigation) (Navigation, error) {
+	data, err := json.Marshal(nav)
+	if err != nil {
+		return Navigation{}, err
+	}
+	var c Navigation
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Navigation{}, err
+	}
+	return c, nil
+}
+
+// writeFileAtomic 先写临时文件并 fsync，再 rename 覆盖目标文件，
+// 保证中途崩溃时目标文件要么是旧内容要么是新内容
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // rename 成功后文件已不存在，删除失败可忽略
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	// 同步目录，确保 rename 落盘
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// ensureLinkIDs 为缺少 id 的链接生成 id，返回是否有修改
+func ensureLinkIDs(nav *Navigation) bool {
+	changed := false
+	for i := range nav.Links {
+		if nav.Links[i].ID == "" {
+			nav.Links[i].ID = generateID()
+			changed = true
+		}
+	}
+	return changed
+}
+
+// findLinkIndex 根据 id 查找链接在数组中的位置，找不到返回 -1
+func findLinkIndex(nav *Navigation, id string) int {
+	for i, link := range nav.Links {
+		if link.ID == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RFC 6238 TOTP 参数，与常见的验证器应用（Google Authenticator 等）的默认值一致
+const (
+	totpIssuer        = "TinyNav"
+	totpPeriod        = 30 // 秒
+	totpDigits        = 6
+	totpSkew          = 1  // 允许前后各偏差一个周期
+	totpRecoveryCount = 10 // 恢复码数量
+)
+
+var envRequireTOTP bool // 是否要求所有用户启用两步验证
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPState 用户的两步验证设置。Secret 在确认前为待启用状态，恢复码只保存 SHA-256
+type TOTPState struct {
+	Secret        string   `json:"secret"`
+	Enabled       bool     `json:"enabled"`
+	EnabledAt     int64    `json:"enabledAt,omitempty"`
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+	LastCounter   int64    `json:"lastCounter,omitempty"` // 最近一次使用的验证码周期，防止同一验证码重复使用
+}
+
+var totpStore *TOTPStore
+
+// TOTPStore 管理所有用户（包括配置文件中的管理员）的两步验证设置
+type TOTPStore struct {
+	mu      sync.Mutex
+	states  map[string]TOTPState // 按用户名
+	storage Storage
+}
+
+func NewTOTPStore(storage Storage) *TOTPStore {
+	s := &TOTPStore{
+		states:  make(map[string]TOTPState),
+		storage: storage,
+	}
+
+	data, err := storage.Load(storageTOTP)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading totp: %v", err)
+		}
+		return s
+	}
+	if err := json.Unmarshal(data, &s.states); err != nil {
+		log.Printf("Error unmarshaling totp: %v", err)
+		s.states = make(map[string]TOTPState)
+	}
+	return s
+}
+
+func (s *TOTPStore) save() error {
+	data, err := json.MarshalIndent(s.states, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling totp: %v", err)
+	}
+	return s.storage.Save(storageTOTP, data)
+}
+
+// Enabled 判断用户是否已启用两步验证
+func (s *TOTPStore) Enabled(username string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.states[username].Enabled
+}
+
+// Status 返回用户是否启用两步验证以及剩余的恢复码数量
+func (s *TOTPStore) Status(username string) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.states[username]
+	return state.Enabled, len(state.RecoveryCodes)
+}
+
+// Enroll 为用户生成新的密钥，确认前不生效。返回密钥和 otpauth URI（可生成二维码）
+func (s *TOTPStore) Enroll(username string) (string, string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret := totpEncoding.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.states[username].Enabled {
+		return "", "", newRequestError(http.StatusConflict, "Two-factor authentication is already enabled")
+	}
+	s.states[username] = TOTPState{Secret: secret}
+	if err := s.save(); err != nil {
+		return "", "", err
+	}
+	return secret, totpURI(username, secret), nil
+}
+
+// Confirm 用验证器生成的验证码确认启用两步验证，返回一次性恢复码
+func (s *TOTPStore) Confirm(username string, code string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[username]
+	if !ok || state.Secret == "" {
+		return nil, newRequestError(http.StatusBadRequest, "Call /totp/enroll first")
+	}
+	if state.Enabled {
+		return nil, newRequestError(http.StatusConflict, "Two-factor authentication is already enabled")
+	}
+	counter, ok := verifyTOTP(state.Secret, code, time.Now())
+	if !ok {
+		return nil, newRequestError(http.StatusBadRequest, "Invalid code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	state.Enabled = true
+	state.EnabledAt = time.Now().Unix()
+	state.RecoveryCodes = hashes
+	state.LastCounter = counter
+	s.states[username] = state
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify 校验登录时的验证码或恢复码，恢复码使用后作废
+func (s *TOTPStore) Verify(username string, code string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[username]
+	if !ok || !state.Enabled {
+		return false
+	}
+
+	if counter, ok := verifyTOTP(state.Secret, code, time.Now()); ok {
+		if counter <= state.LastCounter {
+			return false
+		}
+		state.LastCounter = counter
+	} else {
+		hash := hashToken(normalizeRecoveryCode(code))
+		index := -1
+		for i, h := range state.RecoveryCodes {
+			if constantTimeEqual(h, hash) {
+				index = i
+			}
+		}
+		if index < 0 {
+			return false
+		}
+		state.RecoveryCodes = append(append([]string(nil), state.RecoveryCodes[:index]...), state.RecoveryCodes[index+1:]...)
+		log.Printf("Recovery code used: user=%q remaining=%d", username, len(state.RecoveryCodes))
+	}
+
+	s.states[username] = state
+	if err := s.save(); err != nil {
+		log.Printf("Error saving totp: %v", err)
+	}
+	return true
+}
+
+// Disable 关闭用户的两步验证
+func (s *TOTPStore) Disable(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.states[username]; !ok {
+		return nil
+	}
+	delete(s.states, username)
+	return s.save()
+}
+
+// totpURI 返回验证器应用使用的 otpauth URI
+func totpURI(username string, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", totpDigits))
+	v.Set("period", fmt.Sprintf("%d", totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// verifyTOTP 校验验证码，成功时返回验证码对应的周期
+func verifyTOTP(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+	counter := now.Unix() / totpPeriod
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		if constantTimeEqual(hotp(key, counter+i), code) {
+			return counter + i, true
+		}
+	}
+	return 0, false
+}
+
+// hotp RFC 4226 HMAC-SHA1 一次性密码
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// generateRecoveryCodes 生成恢复码，返回恢复码和它们的哈希
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, totpRecoveryCount)
+	hashes := make([]string, 0, totpRecoveryCount)
+	for i := 0; i < totpRecoveryCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b)) // 8 个字符
+		code = code[:4] + "-" + code[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode 忽略恢复码的大小写、空格和连字符
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// totpEnrollmentPending 判断是否强制启用两步验证而登录的用户尚未启用。
+// 访问令牌只能在登录后创建，外部登录和反向代理认证的用户由身份提供方负责多因素认证，都不受此限制
+func totpEnrollmentPending(info AuthInfo) bool {
+	if !envRequireTOTP || info.Scopes != nil || info.Forwarded || info.Username == "" || totpStore.Enabled(info.Username) {
+		return false
+	}
+	account, ok := userStore.Get(info.Username)
+	return !ok || account.Provider == ""
+}
+
+// totpExemptPath 强制启用两步验证时，尚未启用的用户只能访问这些接口
+func totpExemptPath(path string) bool {
+	return path == "/validate" || path == "/logout" || path == "/totp" || strings.HasPrefix(path, "/totp/")
+}
+
+// TOTPRequest 两步验证接口的请求体
+type TOTPRequest struct {
+	Code string `json:"code"`
+}
+
+// totpHandler 当前用户的两步验证设置，只能用登录令牌调用:
+//
+//	GET  /totp          查看是否启用、是否强制、剩余恢复码数量
+//	POST /totp/enroll   生成新密钥，返回 secret 和 otpauth URI
+//	POST /totp/confirm  提交验证码确认启用，返回恢复码（只返回这一次）
+//	POST /totp/disable  提交验证码或恢复码关闭两步验证
+func totpHandler(w http.ResponseWriter, r *http.Request) {
+	info, _ := requestAuth(r)
+	if info.Scopes != nil {
+		http.Error(w, "Access tokens cannot manage two-factor authentication", http.StatusForbidden)
+		return
+	}
+	if info.Username == "" {
+		http.Error(w, "Two-factor authentication requires a user account", http.StatusBadRequest)
+		return
+	}
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/totp"), "/")
+
+	if action == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		enabled, remaining := totpStore.Status(info.Username)
+		writeJSON(w, map[string]interface{}{
+			"enabled":           enabled,
+			"required":          envRequireTOTP,
+			"recoveryCodesLeft": remaining,
+		})
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TOTPRequest
+	if action != "enroll" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch action {
+	case "enroll":
+		secret, uri, err := totpStore.Enroll(info.Username)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, map[string]string{"secret": secret, "uri": uri})
+	case "confirm":
+		codes, err := totpStore.Confirm(info.Username, req.Code)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("TOTP enabled: user=%q", info.Username)
+		writeJSON(w, map[string][]string{"recoveryCodes": codes})
+	case "disable":
+		// 与登录共用失败计数，防止拿到令牌后暴力尝试验证码或恢复码
+		ip := clientIP(r)
+		if remaining := loginLimiter.Locked(ip, info.Username); remaining > 0 {
+			log.Printf("TOTP disable failed: ip=%s user=%q reason=locked retry_after=%d", ip, info.Username, retryAfterSeconds(remaining))
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfterSeconds(remaining)))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		if !totpStore.Verify(info.Username, req.Code) {
+			lockout := loginLimiter.Fail(ip, info.Username)
+			log.Printf("TOTP disable failed: ip=%s user=%q reason=bad_totp lockout=%d", ip, info.Username, retryAfterSeconds(lockout))
+			http.Error(w, "Invalid code", http.StatusBadRequest)
+			return
+		}
+		loginLimiter.Succeed(ip, info.Username)
+		if err := totpStore.Disable(info.Username); err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("TOTP disabled: user=%q", info.Username)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 用户角色，权限依次递增
+const (
+	roleViewer = "viewer" // 只能浏览
+	roleEditor = "editor" // 可以编辑导航
+	roleAdmin  = "admin"  // 可以管理用户
+)
+
+// roleNone 用于外部登录的默认角色配置，表示没有匹配的角色映射时不允许登录
+const roleNone = "none"
+
+var roleLevels = map[string]int{
+	roleViewer: 1,
+	roleEditor: 2,
+	roleAdmin:  3,
+}
+
+// hasRole 判断 role 是否具有 required 角色的权限
+func hasRole(role string, required string) bool {
+	return roleLevels[role] >= roleLevels[required]
+}
+
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9_.@-]{1,64}$`)
+
+// 用于用户不存在时也执行一次 bcrypt 比较，避免通过耗时判断用户是否存在
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("tiny-nav"), bcrypt.DefaultCost)
+
+var userStore *UserStore
+
+// Account 保存在存储中的用户。配置文件中的 NAV_USERNAME 是内置管理员，不在此列
+type Account struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"passwordHash,omitempty"`
+	Role         string `json:"role"`
+	CreatedAt    int64  `json:"createdAt"`
+	Provider     string `json:"provider,omitempty"` // 外部登录（如 oidc）的用户，没有密码，角色在每次登录时更新
+}
+
+// UserStore 管理用户
+type UserStore struct {
+	mu      sync.RWMutex
+	users   map[string]Account
+	storage Storage
+}
+
+func NewUserStore(storage Storage) *UserStore {
+	us := &UserStore{
+		users:   make(map[string]Account),
+		storage: storage,
+	}
+
+	data, err := storage.Load(storageUsers)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading users: %v", err)
+		}
+		return us
+	}
+	if err := json.Unmarshal(data, &us.users); err != nil {
+		log.Printf("Error unmarshaling users: %v", err)
+		us.users = make(map[string]Account)
+	}
+	return us
+}
+
+func (us *UserStore) save() error {
+	data, err := json.MarshalIndent(us.users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling users: %v", err)
+	}
+	return us.storage.Save(storageUsers, data)
+}
+
+// Get 返回用户，不存在时第二个返回值为 false
+func (us *UserStore) Get(username string) (Account, bool) {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	account, ok := us.users[username]
+	return account, ok
+}
+
+// List 返回所有用户（不含密码哈希），按用户名排序
+func (us *UserStore) List() []Account {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	list := make([]Account, 0, len(us.users))
+	for _, account := range us.users {
+		account.PasswordHash = ""
+		list = append(list, account)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Username < list[j].Username
+	})
+	return list
+}
+
+// Put 新增或修改用户，password 为空时保留原密码
+func (us *UserStore) Put(username string, password string, role string, create bool) (Account, error) {
+	if !validUsername.MatchString(username) {
+		return Account{}, newRequestError(http.StatusBadRequest, "Invalid username")
+	}
+	if username == envUsername {
+		return Account{}, newRequestError(http.StatusConflict, "User '%s' is managed by the config file", username)
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	account, exists := us.users[username]
+	if create && exists {
+		return Account{}, newRequestError(http.StatusConflict, "User '%s' already exists", username)
+	}
+	if !create && !exists {
+		return Account{}, newRequestError(http.StatusNotFound, "User not found")
+	}
+	if exists && account.Provider != "" && password != "" {
+		return Account{}, newRequestError(http.StatusBadRequest, "User '%s' signs in via %s and has no password", username, account.Provider)
+	}
+	if !exists {
+		account = Account{Username: username, CreatedAt: time.Now().Unix()}
+		if password == "" {
+			return Account{}, newRequestError(http.StatusBadRequest, "Password required")
+		}
+	}
+	if role != "" {
+		if _, ok := roleLevels[role]; !ok {
+			return Account{}, newRequestError(http.StatusBadRequest, "Invalid role: %s", role)
+		}
+		account.Role = role
+	}
+	if account.Role == "" {
+		account.Role = roleViewer
+	}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return Account{}, err
+		}
+		account.PasswordHash = string(hash)
+	}
+
+	us.users[username] = account
+	if err := us.save(); err != nil {
+		return Account{}, err
+	}
+	account.PasswordHash = ""
+	return account, nil
+}
+
+// PutExternal 外部登录成功后新增或更新用户，不能与本地用户重名
+func (us *UserStore) PutExternal(username string, role string, provider string) (Account, error) {
+	if !validUsername.MatchString(username) {
+		return Account{}, newRequestError(http.StatusBadRequest, "Invalid username")
+	}
+	if username == envUsername {
+		return Account{}, newRequestError(http.StatusConflict, "User '%s' is managed by the config file", username)
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	account, exists := us.users[username]
+	if exists && account.Provider != provider {
+		return Account{}, newRequestError(http.StatusConflict, "User '%s' already exists", username)
+	}
+	if exists && account.Role == role {
+		return account, nil
+	}
+	if !exists {
+		account = Account{Username: username, CreatedAt: time.Now().Unix(), Provider: provider}
+	}
+	account.Role = role
+	us.users[username] = account
+	if err := us.save(); err != nil {
+		return Account{}, err
+	}
+	return account, nil
+}
+
+// Delete 删除用户
+func (us *UserStore) Delete(username string) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if _, ok := us.users[username]; !ok {
+		return newRequestError(http.StatusNotFound, "User not found")
+	}
+	delete(us.users, username)
+	return us.save()
+}
+
+// Authenticate 校验存储中用户的密码
+func (us *UserStore) Authenticate(username string, password string) bool {
+	account, ok := us.Get(username)
+	if !ok || account.PasswordHash == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) == nil
+}
+
+// authenticate 校验登录的用户名和密码，先匹配配置文件中的管理员，再匹配用户存储
+func authenticate(username string, password string) bool {
+	if envUsername != "" && checkCredentials(username, password) {
+		return true
+	}
+	return userStore.Authenticate(username, password)
+}
+
+// userRole 返回用户当前的角色，用户不存在时第二个返回值为 false。
+// 角色每次请求都实时查询，修改角色或删除用户立即生效。
+func userRole(username string) (string, bool) {
+	// 无用户密码模式下登录得到的 token 不绑定用户
+	if username == "" {
+		return roleAdmin, envEnableNoAuth
+	}
+	if username == envUsername {
+		return roleAdmin, true
+	}
+	account, ok := userStore.Get(username)
+	if !ok {
+		return "", false
+	}
+	return account.Role, true
+}
+
+type contextKey string
+
+const authContextKey contextKey = "auth"
+
+// AuthInfo 当前请求的登录信息
+type AuthInfo struct {
+	Username  string
+	Role      string
+	Token     string
+	Scopes    []string // 访问令牌的权限范围，登录令牌为 nil
+	Forwarded bool     // 由受信任的反向代理认证，没有令牌
+	Cookie    bool     // 令牌来自会话 cookie，修改类请求需要校验 CSRF 令牌
+}
+
+// requestAuth 返回 authMiddleware 放入请求上下文的登录信息
+func requestAuth(r *http.Request) (AuthInfo, bool) {
+	info, ok := r.Context().Value(authContextKey).(AuthInfo)
+	return info, ok
+}
+
+func withAuth(r *http.Request, info AuthInfo) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authContextKey, info))
+}
+
+// roleMiddleware 验证令牌并要求至少具有 role 角色
+func roleMiddleware(role string, next http.HandlerFunc) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		info, _ := requestAuth(r)
+		if !hasRole(info.Role, role) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// UserRequest 新增/修改用户的请求体
+type UserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// usersHandler 用户管理接口（仅管理员）:
+//
+//	GET    /admin/users         列出用户
+//	POST   /admin/users         新增用户
+//	PUT    /admin/users/{name}  修改密码或角色
+//	DELETE /admin/users/{name}  删除用户并使其 token 失效
+//	DELETE /admin/users/{name}/totp  关闭用户的两步验证（如丢失设备和恢复码）
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	username := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/users"), "/")
+
+	if name, ok := strings.CutSuffix(username, "/totp"); ok {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if _, exists := userRole(name); !exists {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err := totpStore.Disable(name); err != nil {
+			writeError(w, err)
+			return
+		}
+		info, _ := requestAuth(r)
+		log.Printf("TOTP reset: user=%q by user=%q", name, info.Username)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch {
+	case username == "" && r.Method == http.MethodGet:
+		writeJSON(w, userStore.List())
+	case username == "" && r.Method == http.MethodPost:
+		var req UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		account, err := userStore.Put(req.Username, req.Password, req.Role, true)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("User created: %s role=%s", account.Username, account.Role)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(account)
+	case username != "" && r.Method == http.MethodPut:
+		var req UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		account, err := userStore.Put(username, req.Password, req.Role, false)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		// 修改密码后，已登录的会话需要重新登录
+		if req.Password != "" {
+			tokenStore.RevokeUser(username)
+		}
+		log.Printf("User updated: %s role=%s", account.Username, account.Role)
+		writeJSON(w, account)
+	case username != "" && r.Method == http.MethodDelete:
+		if err := userStore.Delete(username); err != nil {
+			writeError(w, err)
+			return
+		}
+		tokenStore.RevokeUser(username)
+		apiTokenStore.RevokeUser(username)
+		if err := totpStore.Disable(username); err != nil {
+			log.Printf("Error saving totp: %v", err)
+		}
+		log.Printf("User deleted: %s", username)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}